@@ -1,12 +1,14 @@
 package app
 
 import (
+	"bytes"
 	"log"
-	"os"
+	"math"
 
+	"github.com/faiface/mainthread"
 	"github.com/faiface/pixel"
-	"github.com/faiface/pixel/imdraw"
 	"github.com/faiface/pixel/pixelgl"
+	"github.com/justinawrey/goboy/gb"
 )
 
 const (
@@ -15,34 +17,49 @@ const (
 	pxSize = 4
 )
 
-var (
-	white   = pixel.RGB(1, 1, 1)
-	lighter = pixel.RGB(0.608, 0.737, 0.059)
-	light   = pixel.RGB(0.545, 0.675, 0.059)
-	dark    = pixel.RGB(0.188, 0.384, 0.188)
-	darker  = pixel.RGB(0.059, 0.220, 0.059)
-	colors  = map[int]pixel.RGBA{
-		0: lighter,
-		1: light,
-		2: dark,
-		3: darker,
-	}
-)
+// letterboxing around the scaled frame
+var black = pixel.RGB(0, 0, 0)
+
+var windowKeys = map[pixelgl.Button]gb.Button{
+	pixelgl.KeyUp:         gb.ButtonUp,
+	pixelgl.KeyDown:       gb.ButtonDown,
+	pixelgl.KeyLeft:       gb.ButtonLeft,
+	pixelgl.KeyRight:      gb.ButtonRight,
+	pixelgl.KeyX:          gb.ButtonA,
+	pixelgl.KeyZ:          gb.ButtonB,
+	pixelgl.KeyEnter:      gb.ButtonStart,
+	pixelgl.KeyRightShift: gb.ButtonSelect,
+}
 
-// display.Display implements gb.Renderer
+var slotKeys = []pixelgl.Button{
+	pixelgl.Key0, pixelgl.Key1, pixelgl.Key2, pixelgl.Key3, pixelgl.Key4,
+	pixelgl.Key5, pixelgl.Key6, pixelgl.Key7, pixelgl.Key8, pixelgl.Key9,
+}
+
+// display.Display implements gb.Renderer.
+// frames live in a single texture that the gpu scales
+// up to the window, so only changed rows are uploaded
 type Display struct {
 	*pixelgl.Window
-	*imdraw.IMDraw
+	frontend
+	shownStatus string
+
+	picture pixelgl.GLPicture
+	sprite  *pixel.Sprite
+
+	// rgba rows as last uploaded to the texture
+	uploaded []byte
 }
 
 func Run(run func()) {
 	pixelgl.Run(run)
 }
 
-func NewDisplay() *Display {
+func NewDisplay(gameboy *gb.Gb, rom string) *Display {
 	cfg := pixelgl.WindowConfig{
-		Title:  "goboy",
-		Bounds: pixel.R(0, 0, width*pxSize, height*pxSize),
+		Title:     "goboy",
+		Bounds:    pixel.R(0, 0, width*pxSize, height*pxSize),
+		Resizable: true,
 	}
 
 	window, err := pixelgl.NewWindow(cfg)
@@ -50,39 +67,141 @@ func NewDisplay() *Display {
 		log.Fatal(err)
 	}
 
-	imd := imdraw.New(nil)
-	return &Display{window, imd}
+	picture := pixelgl.NewGLPicture(pixel.MakePictureData(pixel.R(0, 0, width, height)))
+	sprite := pixel.NewSprite(picture, picture.Bounds())
+
+	return &Display{
+		Window:   window,
+		frontend: newFrontend(gameboy, rom),
+		picture:  picture,
+		sprite:   sprite,
+		uploaded: make([]byte, width*height*4),
+	}
+}
+
+func (d *Display) Format() gb.PixelFormat {
+	return gb.FormatRGBA
 }
 
-func (d *Display) Render(pixels []int) {
+func (d *Display) Render(frame *gb.Frame) error {
 	if d.Window.Closed() {
-		d.Window.Destroy()
-		os.Exit(0)
+		return gb.ErrClosed
 	}
 
-	d.IMDraw.Clear()
-	d.IMDraw.Reset()
+	d.handleKeys(frame)
+	d.updateStats()
+	d.upload(frame)
 
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			d.drawPx(pixels, x, y)
-		}
+	if d.status != d.shownStatus {
+		d.Window.SetTitle("goboy - " + d.status)
+		d.shownStatus = d.status
 	}
 
-	d.Window.Clear(white)
-	d.IMDraw.Draw(d.Window)
+	d.Window.Clear(black)
+	d.sprite.Draw(d.Window, d.fit())
 	d.Window.Update()
+	return nil
 }
 
-func (d *Display) drawPx(pixels []int, x int, y int) {
-	px := pixels[(width*y)+x]
-	color := colors[px]
+// keys pressed or released since the last window update
+func (d *Display) handleKeys(frame *gb.Frame) {
+	for key, button := range windowKeys {
+		if d.Window.JustPressed(key) {
+			d.gb.Press(button)
+		}
+		if d.Window.JustReleased(key) {
+			d.gb.Release(button)
+		}
+	}
+
+	for slot, key := range slotKeys {
+		if d.Window.JustPressed(key) {
+			d.selectSlot(slot)
+		}
+	}
+
+	if d.Window.JustPressed(pixelgl.KeyF5) {
+		d.saveSlot(frame)
+	}
+
+	if d.Window.JustPressed(pixelgl.KeyF7) {
+		d.loadSlot()
+	}
+
+	if d.Window.Pressed(pixelgl.KeyBackspace) {
+		d.rewind()
+	}
+
+	if d.Window.JustPressed(pixelgl.KeyP) {
+		d.togglePause()
+	}
+
+	if d.Window.JustPressed(pixelgl.KeyN) {
+		d.advanceFrame()
+	}
+
+	if d.Window.JustPressed(pixelgl.KeyMinus) {
+		d.changeSpeed(-1)
+	}
+
+	if d.Window.JustPressed(pixelgl.KeyEqual) {
+		d.changeSpeed(1)
+	}
+
+	// r resets, shift+r cycles the power
+	if d.Window.JustPressed(pixelgl.KeyR) {
+		hard := d.Window.Pressed(pixelgl.KeyLeftShift) || d.Window.Pressed(pixelgl.KeyRightShift)
+		d.reset(hard)
+	}
+
+	if d.Window.JustPressed(pixelgl.KeyI) {
+		d.toggleStats()
+	}
+
+	// fast-forward while tab is held
+	d.setFastForward(d.Window.Pressed(pixelgl.KeyTab))
+}
+
+// copies the rows of frame that changed since the last upload into the texture
+func (d *Display) upload(frame *gb.Frame) {
+	first, last := -1, -1
+	for y := 0; y < height; y++ {
+		row := frame.Pix[y*frame.Stride : (y+1)*frame.Stride]
+		if !bytes.Equal(row, d.uploaded[y*frame.Stride:(y+1)*frame.Stride]) {
+			if first < 0 {
+				first = y
+			}
+			last = y
+		}
+	}
+
+	if first < 0 {
+		return
+	}
+
+	// textures are bottom up, frames are top down
+	rows := last - first + 1
+	pixels := make([]byte, 0, rows*frame.Stride)
+	for y := last; y >= first; y-- {
+		row := frame.Pix[y*frame.Stride : (y+1)*frame.Stride]
+		copy(d.uploaded[y*frame.Stride:], row)
+		pixels = append(pixels, row...)
+	}
+
+	texture := d.picture.Texture()
+	mainthread.Call(func() {
+		texture.Begin()
+		texture.SetPixels(0, height-1-last, width, rows, pixels)
+		texture.End()
+	})
+}
 
-	lowerX := float64((x % width) * pxSize)
-	lowerY := float64((height - y - 1) * pxSize)
+// scales the frame by the largest integer that fits the
+// window and centers it, leaving black bars on the sides
+func (d *Display) fit() pixel.Matrix {
+	bounds := d.Window.Bounds()
+	scale := math.Floor(math.Min(bounds.W()/width, bounds.H()/height))
+	scale = math.Max(scale, 1)
 
-	d.IMDraw.Color = color
-	d.IMDraw.Push(pixel.V(lowerX, lowerY))
-	d.IMDraw.Push(pixel.V(lowerX+pxSize, lowerY+pxSize))
-	d.IMDraw.Rectangle(0)
+	return pixel.IM.Scaled(pixel.ZV, scale).Moved(bounds.Center())
 }
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"fmt"
+	"image/png"
+	"os"
+	"time"
+
+	"github.com/justinawrey/goboy/gb"
+)
+
+// state shared by the window and terminal frontends.
+// only touched from Render, which runs on a goroutine of its own, one
+// frame at a time, and never from the commands it makes
+type frontend struct {
+	gb  *gb.Gb
+	rom string
+
+	// save state slot used by the save / load hotkeys
+	slot int
+
+	// short message for the user, e.g. "saved slot 1"
+	status string
+
+	// what the clock was last told, indexing speeds
+	paused      bool
+	speed       int
+	fastForward bool
+
+	// frame timing replaces the status while showing stats
+	showStats bool
+	renders   uint64
+}
+
+// speeds the speed up / down hotkeys step through
+var speeds = []float64{0.25, 0.5, 1, 2, 4}
+
+const normalSpeed = 2
+
+func newFrontend(gameboy *gb.Gb, rom string) frontend {
+	return frontend{gb: gameboy, rom: rom, slot: 1, speed: normalSpeed}
+}
+
+// slots live next to the rom: tetris.gb.ss1, with a tetris.gb.ss1.png thumbnail
+func (f *frontend) slotPath() string {
+	return fmt.Sprintf("%s.ss%d", f.rom, f.slot)
+}
+
+func (f *frontend) selectSlot(slot int) {
+	f.slot = slot
+	f.status = fmt.Sprintf("slot %d", slot)
+}
+
+func (f *frontend) saveSlot(frame *gb.Frame) {
+	if err := f.writeSlot(frame); err != nil {
+		f.status = err.Error()
+		return
+	}
+
+	f.status = fmt.Sprintf("saved slot %d", f.slot)
+}
+
+func (f *frontend) loadSlot() {
+	if err := f.readSlot(); err != nil {
+		f.status = err.Error()
+		return
+	}
+
+	f.status = fmt.Sprintf("loaded slot %d", f.slot)
+}
+
+func (f *frontend) writeSlot(frame *gb.Frame) error {
+	state, err := os.Create(f.slotPath())
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	if err := f.gb.SaveState(state); err != nil {
+		return err
+	}
+
+	thumbnail, err := os.Create(f.slotPath() + ".png")
+	if err != nil {
+		return err
+	}
+	defer thumbnail.Close()
+
+	return png.Encode(thumbnail, frame.Image())
+}
+
+func (f *frontend) readSlot() error {
+	state, err := os.Open(f.slotPath())
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	return f.gb.LoadState(state)
+}
+
+// called every frame the rewind key is held.  the main loop moves
+// forward a frame after every render, so this steps back two
+func (f *frontend) rewind() {
+	if err := f.gb.Rewind(2); err != nil {
+		f.status = err.Error()
+	}
+}
+
+func (f *frontend) togglePause() {
+	f.paused = !f.paused
+	f.gb.TogglePause()
+
+	if f.paused {
+		f.status = "paused"
+	} else {
+		f.status = ""
+	}
+}
+
+func (f *frontend) advanceFrame() {
+	if f.paused {
+		f.gb.AdvanceFrame()
+	}
+}
+
+// fast-forward runs as fast as possible, until turned off
+func (f *frontend) setFastForward(on bool) {
+	if on == f.fastForward {
+		return
+	}
+
+	f.fastForward = on
+	if on {
+		f.gb.SetSpeed(0)
+		f.status = "fast-forward"
+		return
+	}
+
+	f.gb.SetSpeed(speeds[f.speed])
+	f.status = ""
+}
+
+// step is +1 to speed up, -1 to slow down
+func (f *frontend) changeSpeed(step int) {
+	f.speed += step
+	if f.speed < 0 {
+		f.speed = 0
+	}
+	if f.speed >= len(speeds) {
+		f.speed = len(speeds) - 1
+	}
+
+	f.fastForward = false
+	f.gb.SetSpeed(speeds[f.speed])
+	f.status = fmt.Sprintf("speed %gx", speeds[f.speed])
+}
+
+func (f *frontend) reset(hard bool) {
+	f.gb.Reset(hard)
+
+	if hard {
+		f.status = "hard reset"
+	} else {
+		f.status = "reset"
+	}
+}
+
+func (f *frontend) toggleStats() {
+	f.showStats = !f.showStats
+	f.status = ""
+}
+
+// called every render, refreshes the stats twice a second
+func (f *frontend) updateStats() {
+	f.renders++
+	if !f.showStats || f.renders%30 != 0 {
+		return
+	}
+
+	stats := f.gb.Stats()
+	fps := 0.0
+	if stats.Mean > 0 {
+		fps = float64(time.Second) / float64(stats.Mean)
+	}
+
+	f.status = fmt.Sprintf("%.1f fps, jitter %v, %d skipped, %d repeated",
+		fps, stats.Jitter.Round(time.Microsecond), stats.Skipped, stats.Repeated)
+}
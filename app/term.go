@@ -0,0 +1,325 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/justinawrey/goboy/gb"
+)
+
+// terminals don't report key releases, so a button stays
+// held for this many frames after its last key press (or repeat)
+const holdFrames = 10
+
+// each cell draws two pixels: the upper with the foreground color
+// and the lower with the background color
+const halfBlock = "▀"
+
+var termKeys = map[string]gb.Button{
+	"up":    gb.ButtonUp,
+	"down":  gb.ButtonDown,
+	"left":  gb.ButtonLeft,
+	"right": gb.ButtonRight,
+	"w":     gb.ButtonUp,
+	"s":     gb.ButtonDown,
+	"a":     gb.ButtonLeft,
+	"d":     gb.ButtonRight,
+	"x":     gb.ButtonA,
+	"z":     gb.ButtonB,
+	"enter": gb.ButtonStart,
+	"space": gb.ButtonSelect,
+}
+
+// Terminal implements gb.Renderer using ansi escapes,
+// for when there is no window system (e.g. over ssh)
+type Terminal struct {
+	frontend
+	shownStatus string
+
+	tty       *os.File
+	out       *bufio.Writer
+	sttyMode  string
+	trueColor bool
+
+	keys chan string
+
+	// frame numbers jump around with save states and rewind,
+	// so holds are timed by counting renders instead
+	renders     uint64
+	held        map[gb.Button]uint64
+	rewindUntil uint64
+
+	// shades currently on screen, 0xff when unknown
+	screen [height][width]byte
+}
+
+func NewTerminal(gameboy *gb.Gb, rom string) (*Terminal, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Terminal{
+		frontend:  newFrontend(gameboy, rom),
+		tty:       tty,
+		out:       bufio.NewWriterSize(tty, 1<<16),
+		trueColor: isTrueColor(),
+		keys:      make(chan string, 64),
+		held:      make(map[gb.Button]uint64),
+	}
+
+	t.sttyMode, err = t.stty("-g")
+	if err != nil {
+		tty.Close()
+		return nil, err
+	}
+
+	if _, err := t.stty("raw", "-echo"); err != nil {
+		tty.Close()
+		return nil, err
+	}
+
+	t.invalidate()
+
+	// alternate screen, hide cursor, clear
+	t.out.WriteString("\x1b[?1049h\x1b[?25l\x1b[2J")
+	t.out.Flush()
+
+	go t.readKeys()
+	return t, nil
+}
+
+// Destroy restores the terminal to how it was before NewTerminal
+func (t *Terminal) Destroy() {
+	t.out.WriteString("\x1b[0m\x1b[?25h\x1b[?1049l")
+	t.out.Flush()
+	t.stty(t.sttyMode)
+	t.tty.Close()
+}
+
+func (t *Terminal) Format() gb.PixelFormat {
+	return gb.FormatShades
+}
+
+func (t *Terminal) Render(frame *gb.Frame) error {
+	if err := t.handleKeys(frame); err != nil {
+		return err
+	}
+	t.updateStats()
+
+	// last colors written, so runs of equal colors skip the escape
+	fg, bg := -1, -1
+	// where the cursor is, -1 when it needs to be moved
+	cursorX, cursorY := -1, -1
+
+	for row := 0; row < height/2; row++ {
+		for x := 0; x < width; x++ {
+			upper := frame.Shade(x, row*2)
+			lower := frame.Shade(x, row*2+1)
+			cell := upper<<2 | lower
+
+			if t.screen[row][x] == cell {
+				continue
+			}
+			t.screen[row][x] = cell
+
+			if cursorX != x || cursorY != row {
+				fmt.Fprintf(t.out, "\x1b[%d;%dH", row+1, x+1)
+			}
+
+			if int(upper) != fg {
+				t.out.WriteString(t.color(38, upper))
+				fg = int(upper)
+			}
+
+			if int(lower) != bg {
+				t.out.WriteString(t.color(48, lower))
+				bg = int(lower)
+			}
+
+			t.out.WriteString(halfBlock)
+			cursorX, cursorY = x+1, row
+		}
+	}
+
+	if t.status != t.shownStatus {
+		fmt.Fprintf(t.out, "\x1b[%d;1H\x1b[0m\x1b[2K%s", height/2+1, t.status)
+		t.shownStatus = t.status
+	}
+
+	return t.out.Flush()
+}
+
+// forces the next frame to redraw every cell
+func (t *Terminal) invalidate() {
+	for row := range t.screen {
+		for x := range t.screen[row] {
+			t.screen[row][x] = 0xff
+		}
+	}
+}
+
+// layer is 38 for foreground or 48 for background
+func (t *Terminal) color(layer int, shade byte) string {
+	c := gb.Palette[shade]
+
+	if t.trueColor {
+		return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", layer, c.R, c.G, c.B)
+	}
+
+	// closest entry in the xterm 6x6x6 color cube
+	cube := func(v uint8) int { return (int(v)*5 + 127) / 255 }
+	index := 16 + 36*cube(c.R) + 6*cube(c.G) + cube(c.B)
+	return fmt.Sprintf("\x1b[%d;5;%dm", layer, index)
+}
+
+// applies pending key presses to the joypad and
+// releases buttons whose hold has run out
+func (t *Terminal) handleKeys(frame *gb.Frame) error {
+	t.renders++
+
+	for pending := true; pending; {
+		select {
+		case key, ok := <-t.keys:
+			if !ok || key == "quit" {
+				return gb.ErrClosed
+			}
+
+			switch {
+			case len(key) == 1 && key[0] >= '0' && key[0] <= '9':
+				t.selectSlot(int(key[0] - '0'))
+				continue
+			case key == "f5":
+				t.saveSlot(frame)
+				continue
+			case key == "f7":
+				t.loadSlot()
+				continue
+			case key == "backspace":
+				t.rewindUntil = t.renders + holdFrames
+				continue
+			case key == "p":
+				t.togglePause()
+				continue
+			case key == "n":
+				t.advanceFrame()
+				continue
+			case key == "-":
+				t.changeSpeed(-1)
+				continue
+			case key == "=":
+				t.changeSpeed(1)
+				continue
+			case key == "r" || key == "R":
+				t.reset(key == "R")
+				continue
+			case key == "i":
+				t.toggleStats()
+				continue
+			case key == "tab":
+				// no key releases, so tab toggles instead of holding
+				t.setFastForward(!t.fastForward)
+				continue
+			}
+
+			button, ok := termKeys[strings.ToLower(key)]
+			if !ok {
+				continue
+			}
+
+			if _, held := t.held[button]; !held {
+				t.gb.Press(button)
+			}
+			t.held[button] = t.renders + holdFrames
+		default:
+			pending = false
+		}
+	}
+
+	if t.renders < t.rewindUntil {
+		t.rewind()
+	}
+
+	for button, until := range t.held {
+		if t.renders >= until {
+			t.gb.Release(button)
+			delete(t.held, button)
+		}
+	}
+
+	return nil
+}
+
+// reads raw key presses from the tty until it is closed
+func (t *Terminal) readKeys() {
+	defer close(t.keys)
+	buf := make([]byte, 64)
+
+	for {
+		n, err := t.tty.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for _, key := range parseKeys(buf[:n]) {
+			t.keys <- key
+		}
+	}
+}
+
+// escape sequences, minus the leading "\x1b[" or "\x1bO"
+var escapeKeys = map[string]string{
+	"A":   "up",
+	"B":   "down",
+	"C":   "right",
+	"D":   "left",
+	"15~": "f5",
+	"18~": "f7",
+}
+
+func parseKeys(buf []byte) (keys []string) {
+	for i := 0; i < len(buf); i++ {
+		switch b := buf[i]; {
+		case b == 0x1b && i+2 < len(buf) && (buf[i+1] == '[' || buf[i+1] == 'O'):
+			// parameters, then a final byte
+			end := i + 2
+			for end < len(buf)-1 && (buf[end] >= '0' && buf[end] <= '9' || buf[end] == ';') {
+				end++
+			}
+
+			if key, ok := escapeKeys[string(buf[i+2:end+1])]; ok {
+				keys = append(keys, key)
+			}
+			i = end
+		case b == 0x03 || b == 'q':
+			keys = append(keys, "quit")
+		case b == '\r' || b == '\n':
+			keys = append(keys, "enter")
+		case b == ' ':
+			keys = append(keys, "space")
+		case b == '\t':
+			keys = append(keys, "tab")
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, "backspace")
+		case b >= 0x20 && b < 0x7f:
+			keys = append(keys, string(b))
+		}
+	}
+
+	return keys
+}
+
+func (t *Terminal) stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = t.tty
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func isTrueColor() bool {
+	colorTerm := os.Getenv("COLORTERM")
+	return colorTerm == "truecolor" || colorTerm == "24bit"
+}
@@ -4,45 +4,87 @@ import (
 	"html/template"
 	"log"
 	"os"
+	"sort"
 
 	"github.com/justinawrey/goboy/gb"
+	"github.com/justinawrey/goboy/singlestep"
 )
 
 type instructionData struct {
-	Instructions8  map[uint8]gb.Instruction
-	Instructions16 map[uint16]gb.Instruction
+	Instructions8  []instructionStatus
+	Instructions16 []instructionStatus
+	Vectors        bool
 }
 
-func Generate() {
-	data := instructionData{
-		Instructions8:  gb.InstructionTable8,
-		Instructions16: gb.InstructionTable16,
+type instructionStatus struct {
+	gb.Instruction
+
+	// with vectors, whether it passes them all, else whether it's marked
+	// implemented
+	Done bool
+
+	// how it did against its vectors, if it has any
+	Result *singlestep.Result
+
+	// its key in the instruction table
+	op uint16
+}
+
+// Generate writes the chart to audit.html.  with vector results from
+// singlestep, an instruction counts as implemented if it passes all its
+// vectors, else if it's marked implemented
+func Generate(results []singlestep.Result) {
+	byOpcode := make(map[uint16]*singlestep.Result)
+	for i := range results {
+		byOpcode[results[i].Opcode] = &results[i]
+	}
+
+	status := func(in gb.Instruction, op, vectors uint16) instructionStatus {
+		s := instructionStatus{Instruction: in, Done: in.Implemented, Result: byOpcode[vectors], op: op}
+		if s.Result != nil {
+			s.Done = s.Result.Passes()
+		}
+		return s
 	}
 
+	data := instructionData{Vectors: len(results) > 0}
+	for op, in := range gb.InstructionTable8 {
+		data.Instructions8 = append(data.Instructions8, status(in, uint16(op), uint16(op)))
+	}
+	for op, in := range gb.InstructionTable16 {
+		// stop's vectors are 10.json
+		vectors := op
+		if op == 0x1000 {
+			vectors = 0x10
+		}
+		data.Instructions16 = append(data.Instructions16, status(in, op, vectors))
+	}
+	sortByOpcode(data.Instructions8)
+	sortByOpcode(data.Instructions16)
+
 	const tmpl = `
 	<h1>CPU instruction completion status</h1>
+	{{if .Vectors}}<p>Checked against the single step test vectors</p>{{end}}
 
 	<h2>8-bit Instructions</h2>
 	<ul>
 			{{range $_, $instruction := .Instructions8}}
-					{{if $instruction.Implemented }}
-							<li style="color: green">{{$instruction.Encoding}}: {{$instruction.Mnemonic}}</li>
-					{{else}}
-							<li style="color: red">{{$instruction.Encoding}}: {{$instruction.Mnemonic}}</li>
-					{{end}}
+					{{template "instruction" $instruction}}
 			{{end}}
 	</ul>
 
 	<h2>16-bit Instructions</h2>
 	<ul>
 			{{range $_, $instruction := .Instructions16}}
-					{{if $instruction.Implemented }}
-							<li style="color: green">{{$instruction.Encoding}}: {{$instruction.Mnemonic}}</li>
-					{{else}}
-							<li style="color: red">{{$instruction.Encoding}}: {{$instruction.Mnemonic}}</li>
-					{{end}}
+					{{template "instruction" $instruction}}
 			{{end}}
-	</ul>`
+	</ul>
+
+	{{define "instruction"}}
+			<li style="color: {{if .Done}}green{{else}}red{{end}}">{{.Encoding}}: {{.Mnemonic}}
+			{{- with .Result}} ({{.Passed}}/{{.Cases}} vectors{{if .Failure}}, {{.Failure}}{{end}}){{end}}
+			{{- if and .Result (ne .Done .Implemented)}}{{if .Implemented}} marked implemented{{else}} not marked implemented{{end}}{{end}}</li>
+	{{end}}`
 
 	t, err := template.New("audit").Parse(tmpl)
 	if err != nil {
@@ -60,3 +102,7 @@ func Generate() {
 		log.Fatal(err)
 	}
 }
+
+func sortByOpcode(s []instructionStatus) {
+	sort.Slice(s, func(i, j int) bool { return s[i].op < s[j].op })
+}
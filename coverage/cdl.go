@@ -0,0 +1,39 @@
+package coverage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/justinawrey/goboy/gb"
+)
+
+// ForRom is where a rom's code/data log is kept, next to it
+func ForRom(rom string) string {
+	return strings.TrimSuffix(rom, filepath.Ext(rom)) + ".cdl"
+}
+
+// Load reads the code/data log at path, for a rom of size bytes, so a
+// run can add to it.  a log that doesn't exist yet is empty
+func Load(path string, size int) (gb.CodeDataLog, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(gb.CodeDataLog, size), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) != size {
+		return nil, fmt.Errorf("coverage: %s is for a %d byte rom, not %d", path, len(data), size)
+	}
+
+	return gb.CodeDataLog(data), nil
+}
+
+// Save writes cdl to path
+func Save(path string, cdl gb.CodeDataLog) error {
+	return os.WriteFile(path, cdl, 0644)
+}
@@ -0,0 +1,73 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+
+	"github.com/justinawrey/goboy/gb"
+)
+
+// WriteHTML writes the report as a single page: each routine's coverage,
+// then the disassembly with what ran, what didn't and what's data marked
+func (r *Report) WriteHTML(w io.Writer, title string) error {
+	return page.Execute(w, struct {
+		Title string
+		*Report
+	}{title, r})
+}
+
+var page = template.Must(template.New("coverage").Funcs(template.FuncMap{
+	"percent": func(hit, n int) string { return fmt.Sprintf("%.1f%%", Percent(hit, n)) },
+	"class": func(line Line) string {
+		switch {
+		case line.Hit:
+			return "hit"
+		case line.Counted:
+			return "miss"
+		case line.Flags&gb.CDLGraphics != 0:
+			return "gfx"
+		case line.Flags&gb.CDLData != 0:
+			return "data"
+		}
+		return ""
+	},
+	"addr":  func(line Line) string { return fmt.Sprintf("%02x:%04x", line.Bank, line.Addr) },
+	"bytes": func(line Line) string { return fmt.Sprintf("% x", line.Bytes) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.1em 0.8em; text-align: left; }
+td.n { text-align: right; }
+pre { line-height: 1.3; }
+.hit { background: #c8f0c8; }
+.miss { background: #f6c8c8; }
+.data { color: #888; }
+.gfx { color: #66a; }
+a { color: inherit; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Hit}} of {{.Counted}} instructions ran, {{percent .Hit .Counted}}.
+<span class="hit">ran</span> <span class="miss">didn't run</span>
+<span class="data">data</span> <span class="gfx">graphics</span></p>
+{{if .Routines}}<table>
+<tr><th>routine</th><th>at</th><th>instructions</th><th>ran</th><th></th></tr>
+{{range $i, $r := .Routines}}{{if $r.Counted}}<tr class="{{if $r.Hit}}{{if eq $r.Hit $r.Counted}}hit{{end}}{{else}}miss{{end}}">
+<td><a href="#r{{$i}}">{{$r.Name}}</a></td><td>{{printf "%02x:%04x" $r.Bank $r.Addr}}</td>
+<td class="n">{{$r.Counted}}</td><td class="n">{{$r.Hit}}</td><td class="n">{{percent $r.Hit $r.Counted}}</td></tr>
+{{end}}{{end}}</table>
+{{end}}<pre>
+{{- range .Lines}}{{if .Label}}
+<span{{if and (ge .Routine 0) (eq .Label (index $.Routines .Routine).Name)}} id="r{{.Routine}}"{{end}}>{{.Label}}:</span>{{end}}
+<span class="{{class .}}">{{addr .}}  {{printf "%-9s" (bytes .)}}  {{.Text}}</span>{{end}}
+</pre>
+</body>
+</html>
+`))
@@ -0,0 +1,253 @@
+package coverage
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/justinawrey/goboy/disasm"
+	"github.com/justinawrey/goboy/gb"
+	"github.com/justinawrey/goboy/symbols"
+)
+
+const bankSize = 0x4000
+
+// the cartridge header, which is data whether it's read or not
+const headerStart, headerEnd = 0x0104, 0x0150
+
+// runs of at least this many 00s or ffs are taken to be padding
+const minFill = 16
+
+// Report is a rom's disassembly, marked with what a code/data log saw
+type Report struct {
+	Lines    []Line
+	Routines []Routine
+
+	// instructions that count towards coverage, and those that ran
+	Counted, Hit int
+}
+
+// Line is an instruction of the disassembly
+type Line struct {
+	disasm.Line
+
+	// the CDL flags of its bytes, or'd together
+	Flags byte
+
+	// whether it's code that counts towards coverage, and whether it ran.
+	// what didn't run doesn't count if it was read as data, or is the
+	// header or padding, and with symbols neither does anything outside
+	// a routine
+	Counted, Hit bool
+
+	// where it is in the listing WriteListing writes, from 1
+	Number int
+
+	// index of the routine it's in, -1 if none
+	Routine int
+}
+
+// Routine is the coverage of the code from a symbol to the next one
+type Routine struct {
+	symbols.Symbol
+
+	Counted, Hit int
+
+	// whether its first instruction ran
+	Entered bool
+
+	// its first line
+	Number int
+}
+
+// New disassembles every bank of rom and marks it with cdl.  routines
+// are named by syms, which may be nil
+func New(rom []byte, cdl gb.CodeDataLog, syms *symbols.Table) (*Report, error) {
+	if len(cdl) != len(rom) {
+		return nil, fmt.Errorf("coverage: the cdl is for a %d byte rom, not %d", len(cdl), len(rom))
+	}
+
+	r := new(Report)
+	routines := make(map[string]int)
+	number := 0
+	fill := fills(rom)
+
+	for bank := 0; bank*bankSize < len(rom); bank++ {
+		start := uint16(0)
+		if bank > 0 {
+			start = bankSize
+		}
+
+		lines, err := disasm.Rom(rom, bank, start, start+bankSize-1, syms)
+		if err != nil {
+			return nil, err
+		}
+
+		// laid out the way disasm.Write does: a section header and a
+		// blank line, then labels on lines of their own.  banks are
+		// separated by a blank line
+		if bank > 0 {
+			number++
+		}
+		number += 2
+
+		for _, dl := range lines {
+			if dl.Label != "" {
+				number++
+			}
+			number++
+
+			line := Line{Line: dl, Number: number, Routine: -1}
+			offset := bank*bankSize + int(dl.Addr-start)
+			for _, flags := range cdl[offset : offset+len(dl.Bytes)] {
+				line.Flags |= flags
+			}
+
+			if sym, ok := syms.Routine(bank, dl.Addr); ok {
+				i, seen := routines[sym.Name]
+				if !seen {
+					i = len(r.Routines)
+					routines[sym.Name] = i
+					r.Routines = append(r.Routines, Routine{Symbol: sym, Number: number})
+				}
+				line.Routine = i
+			}
+
+			code := line.Flags&(gb.CDLCode|gb.CDLOperand) != 0
+			data := line.Flags&(gb.CDLData|gb.CDLGraphics) != 0 ||
+				offset >= headerStart && offset < headerEnd || fill[offset]
+			line.Counted = (code || !data) && (line.Routine >= 0 || syms.Len() == 0)
+			line.Hit = line.Counted && cdl[offset]&gb.CDLCode != 0
+
+			r.count(line)
+			r.Lines = append(r.Lines, line)
+		}
+	}
+
+	return r, nil
+}
+
+// which bytes of rom are padding
+func fills(rom []byte) []bool {
+	fill := make([]bool, len(rom))
+
+	for start := 0; start < len(rom); {
+		end := start + 1
+		for end < len(rom) && rom[end] == rom[start] {
+			end++
+		}
+
+		if end-start >= minFill && (rom[start] == 0x00 || rom[start] == 0xff) {
+			for i := start; i < end; i++ {
+				fill[i] = true
+			}
+		}
+		start = end
+	}
+
+	return fill
+}
+
+func (r *Report) count(line Line) {
+	if line.Routine >= 0 {
+		routine := &r.Routines[line.Routine]
+		if line.Addr == routine.Addr {
+			routine.Entered = line.Hit
+		}
+		if line.Counted {
+			routine.Counted++
+		}
+		if line.Hit {
+			routine.Hit++
+		}
+	}
+
+	if line.Counted {
+		r.Counted++
+	}
+	if line.Hit {
+		r.Hit++
+	}
+}
+
+// Percent is how much of n was hit
+func Percent(hit, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	return 100 * float64(hit) / float64(n)
+}
+
+// WriteListing writes the disassembly, as the source the line numbers
+// of WriteLcov refer to
+func (r *Report) WriteListing(w io.Writer) error {
+	for i := 0; i < len(r.Lines); {
+		bank := r.Lines[i].Bank
+		end := i
+		for end < len(r.Lines) && r.Lines[end].Bank == bank {
+			end++
+		}
+
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+
+		lines := make([]disasm.Line, 0, end-i)
+		for _, line := range r.Lines[i:end] {
+			lines = append(lines, line.Line)
+		}
+		if err := disasm.Write(w, lines); err != nil {
+			return err
+		}
+
+		i = end
+	}
+
+	return nil
+}
+
+// WriteLcov writes the report as an lcov tracefile for the listing at
+// path, with a function for each routine, so genhtml and editors' coverage
+// gutters can show it
+func (r *Report) WriteLcov(w io.Writer, listing string) error {
+	fmt.Fprintf(w, "TN:\nSF:%s\n", listing)
+
+	functions, entered := 0, 0
+	for _, routine := range r.Routines {
+		if routine.Counted == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "FN:%d,%s\n", routine.Number, routine.Name)
+		functions++
+	}
+	for _, routine := range r.Routines {
+		if routine.Counted == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "FNDA:%d,%s\n", boolCount(routine.Entered), routine.Name)
+		if routine.Entered {
+			entered++
+		}
+	}
+	fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", functions, entered)
+
+	for _, line := range r.Lines {
+		if line.Counted {
+			fmt.Fprintf(w, "DA:%d,%d\n", line.Number, boolCount(line.Hit))
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", r.Counted, r.Hit)
+	return err
+}
+
+// a cdl only knows whether something ran, so that's the count
+func boolCount(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// message is any debug adapter protocol message.  requests come in,
+// responses and events go out
+type message struct {
+	Seq  int    `json:"seq"`
+	Type string `json:"type"`
+
+	// requests
+	Command   string          `json:"command,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+
+	// responses
+	RequestSeq int    `json:"request_seq,omitempty"`
+	Success    *bool  `json:"success,omitempty"`
+	Message    string `json:"message,omitempty"`
+
+	// events
+	Event string `json:"event,omitempty"`
+
+	Body interface{} `json:"body,omitempty"`
+}
+
+// transport reads and writes messages framed by a Content-Length header
+type transport struct {
+	r *textproto.Reader
+
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+func newTransport(r io.Reader, w io.Writer) *transport {
+	return &transport{r: textproto.NewReader(bufio.NewReader(r)), w: w}
+}
+
+func (t *transport) read() (*message, error) {
+	header, err := t.r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, errors.New("dap: bad Content-Length")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(t.r.R, body); err != nil {
+		return nil, err
+	}
+
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+// safe to call from any goroutine, events are sent from the one
+// waiting for the Gb to stop
+func (t *transport) write(msg *message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seq++
+	msg.Seq = t.seq
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(t.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (t *transport) respond(req *message, body interface{}) error {
+	success := true
+	return t.write(&message{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: &success, Body: body})
+}
+
+func (t *transport) fail(req *message, err error) error {
+	success := false
+	return t.write(&message{
+		Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: &success, Message: err.Error(),
+		Body: map[string]interface{}{"error": map[string]interface{}{"id": 1, "format": err.Error()}},
+	})
+}
+
+func (t *transport) event(event string, body interface{}) error {
+	return t.write(&message{Type: "event", Event: event, Body: body})
+}
@@ -0,0 +1,35 @@
+package dap
+
+import (
+	"io"
+	"log"
+	"net"
+)
+
+// Serve runs one debug session over r and w, like stdin and stdout
+func Serve(r io.Reader, w io.Writer) error {
+	s := &session{t: newTransport(r, w)}
+	return s.serve()
+}
+
+// ListenAndServe runs debug sessions for clients connecting to a tcp
+// address, one at a time
+func ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		if err := Serve(c, c); err != nil {
+			log.Print(err)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,862 @@
+package dap
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/justinawrey/goboy/disasm"
+	"github.com/justinawrey/goboy/expr"
+	"github.com/justinawrey/goboy/gb"
+	"github.com/justinawrey/goboy/symbols"
+)
+
+// the gameboy has a single thread of execution
+const threadID = 1
+
+// variablesReference values for the scopes
+const (
+	refRegisters = iota + 1
+	refIO
+	refFlags
+)
+
+var errNotLaunched = errors.New("no rom has been launched")
+
+// session debugs one rom for one client
+type session struct {
+	t *transport
+
+	gb      *gb.Gb
+	symbols *symbols.Table
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	stopOnEntry bool
+
+	mu sync.Mutex
+
+	// the client's id for each Gb breakpoint
+	clientIDs map[int]int
+	nextID    int
+
+	// Gb breakpoints set by the latest setFunctionBreakpoints
+	// and setInstructionBreakpoints
+	functionBps    []int
+	instructionBps []int
+
+	// Gb breakpoints standing in for a step over or out
+	temps map[int]bool
+
+	// run once the current request has its response, so clients
+	// hear about a stop after the request that caused it
+	deferred []func()
+}
+
+type launchArgs struct {
+	Program     string `json:"program"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+
+	// an rgblink .sym or .map file, for breakpoints by name and stack
+	// traces.  defaults to one next to the rom
+	Symbols string `json:"symbols"`
+
+	// seconds of history to step back through, 10 if left out
+	History *float64 `json:"history"`
+}
+
+const (
+	defaultHistory = 10
+
+	// snapshotting every frame keeps steps back quick
+	historyGranularity = 1
+)
+
+// handles requests until the client disconnects
+func (s *session) serve() error {
+	defer s.shutdown()
+
+	for {
+		req, err := s.t.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if req.Type != "request" {
+			continue
+		}
+
+		body, err := s.handle(req)
+		if err != nil {
+			s.t.fail(req, err)
+			continue
+		}
+
+		if err := s.t.respond(req, body); err != nil {
+			return err
+		}
+
+		for _, f := range s.deferred {
+			f()
+		}
+		s.deferred = nil
+
+		switch req.Command {
+		case "launch":
+			s.t.event("initialized", nil)
+		case "disconnect", "terminate":
+			s.shutdown()
+			s.t.event("terminated", nil)
+			return nil
+		}
+	}
+}
+
+func (s *session) handle(req *message) (interface{}, error) {
+	if req.Command == "initialize" {
+		return capabilities, nil
+	}
+	if req.Command == "launch" {
+		return nil, s.launch(req.Arguments)
+	}
+	if req.Command == "disconnect" || req.Command == "terminate" {
+		return nil, nil
+	}
+
+	if s.gb == nil {
+		return nil, errNotLaunched
+	}
+
+	switch req.Command {
+	case "configurationDone":
+		return nil, s.configurationDone()
+	case "setBreakpoints":
+		return s.setSourceBreakpoints(req.Arguments)
+	case "setFunctionBreakpoints":
+		return s.setFunctionBreakpoints(req.Arguments)
+	case "setInstructionBreakpoints":
+		return s.setInstructionBreakpoints(req.Arguments)
+	case "threads":
+		return map[string]interface{}{"threads": []map[string]interface{}{{"id": threadID, "name": "cpu"}}}, nil
+	case "stackTrace":
+		return s.stackTrace(), nil
+	case "scopes":
+		return scopes, nil
+	case "variables":
+		return s.variables(req.Arguments)
+	case "continue":
+		s.after(s.gb.Resume)
+		return map[string]interface{}{"allThreadsContinued": true}, nil
+	case "next":
+		return nil, s.next()
+	case "stepIn":
+		s.gb.Step()
+		s.stoppedAfter("step")
+		return nil, nil
+	case "stepOut":
+		return nil, s.stepOut()
+	case "stepBack":
+		if err := s.gb.ReverseStep(); err != nil {
+			return nil, err
+		}
+		s.stoppedAfter("step")
+		return nil, nil
+	case "reverseContinue":
+		s.after(s.reverseContinue)
+		return nil, nil
+	case "pause":
+		s.gb.Pause()
+		s.stoppedAfter("pause")
+		return nil, nil
+	case "evaluate":
+		return s.evaluate(req.Arguments)
+	case "readMemory":
+		return s.readMemory(req.Arguments)
+	case "writeMemory":
+		return s.writeMemory(req.Arguments)
+	case "disassemble":
+		return s.disassemble(req.Arguments)
+	}
+
+	return nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+var capabilities = map[string]interface{}{
+	"supportsConfigurationDoneRequest":  true,
+	"supportsFunctionBreakpoints":       true,
+	"supportsInstructionBreakpoints":    true,
+	"supportsReadMemoryRequest":         true,
+	"supportsWriteMemoryRequest":        true,
+	"supportsDisassembleRequest":        true,
+	"supportsSteppingGranularity":       true,
+	"supportsTerminateRequest":          true,
+	"supportsConditionalBreakpoints":    true,
+	"supportsHitConditionalBreakpoints": true,
+	"supportsLogPoints":                 true,
+	"supportsEvaluateForHovers":         true,
+	"supportsStepBack":                  true,
+}
+
+var scopes = map[string]interface{}{
+	"scopes": []map[string]interface{}{
+		{"name": "Registers", "variablesReference": refRegisters, "expensive": false},
+		{"name": "I/O", "variablesReference": refIO, "expensive": false},
+	},
+}
+
+// loads the rom into a headless Gb, paused until configurationDone
+func (s *session) launch(raw json.RawMessage) error {
+	if s.gb != nil {
+		return errors.New("already launched")
+	}
+
+	var args launchArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return err
+	}
+
+	// LoadCartridge gives up on the whole process for a missing rom
+	if _, err := os.Stat(args.Program); err != nil {
+		return err
+	}
+
+	var err error
+	if args.Symbols != "" {
+		s.symbols, err = symbols.Load(args.Symbols)
+	} else {
+		s.symbols, err = symbols.ForRom(args.Program)
+	}
+	if err != nil {
+		return err
+	}
+
+	gameboy := gb.NewGb()
+	gameboy.LoadCartridge(args.Program)
+	gameboy.EnableCallStack(true)
+	gameboy.SetUnimplementedPolicy(gb.UnimplementedPause)
+	gameboy.Pause()
+
+	history := float64(defaultHistory)
+	if args.History != nil {
+		history = *args.History
+	}
+	gameboy.EnableRewind(history, historyGranularity)
+
+	// boot now, so requests don't race Run booting it
+	gameboy.Reset(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.gb, s.cancel, s.done = gameboy, cancel, make(chan struct{})
+	s.stopOnEntry = args.StopOnEntry
+
+	go s.run(ctx)
+	go s.watchStops(ctx)
+	return nil
+}
+
+func (s *session) run(ctx context.Context) {
+	defer close(s.done)
+
+	err := s.gb.Run(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		s.t.event("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+	}
+	s.t.event("terminated", nil)
+}
+
+func (s *session) shutdown() {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+		s.cancel = nil
+	}
+}
+
+func (s *session) configurationDone() error {
+	if s.stopOnEntry {
+		s.stoppedAfter("entry")
+		return nil
+	}
+
+	s.after(s.gb.Resume)
+	return nil
+}
+
+// turns breakpoints and finished steps into stopped events
+func (s *session) watchStops(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case stop := <-s.gb.Stops():
+			s.stopped(stop)
+		}
+	}
+}
+
+func (s *session) stopped(stop gb.Stop) {
+	s.mu.Lock()
+	reason := "breakpoint"
+	if s.temps[stop.Breakpoint] {
+		reason = "step"
+	}
+
+	var hit []int
+	if id, ok := s.clientIDs[stop.Breakpoint]; ok && reason == "breakpoint" {
+		hit = append(hit, id)
+	}
+
+	temps := s.temps
+	s.temps = nil
+	s.mu.Unlock()
+
+	for id := range temps {
+		s.gb.RemoveBreakpoint(id)
+	}
+
+	switch stop.Reason {
+	case gb.StopFrame:
+		reason = "pause"
+	case gb.StopHistoryStart:
+		reason = "start of history"
+	case gb.StopUnimplemented:
+		s.t.event("stopped", map[string]interface{}{
+			"reason":            "exception",
+			"description":       "unimplemented instruction",
+			"text":              stop.Unimplemented.Error(),
+			"threadId":          threadID,
+			"allThreadsStopped": true,
+		})
+		return
+	}
+
+	s.stoppedEvent(reason, hit)
+}
+
+// its stop comes through Stops like a forward one
+func (s *session) reverseContinue() {
+	if err := s.gb.ReverseContinue(); err != nil {
+		s.t.event("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+		s.stoppedEvent("pause", nil)
+	}
+}
+
+func (s *session) after(f func()) {
+	s.deferred = append(s.deferred, f)
+}
+
+// for stops the request being handled caused
+func (s *session) stoppedAfter(reason string) {
+	s.after(func() { s.stoppedEvent(reason, nil) })
+}
+
+func (s *session) stoppedEvent(reason string, hit []int) {
+	body := map[string]interface{}{
+		"reason":            reason,
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	}
+	if len(hit) > 0 {
+		body["hitBreakpointIds"] = hit
+	}
+
+	s.t.event("stopped", body)
+}
+
+// steps over calls by running to a temporary breakpoint after them
+func (s *session) next() error {
+	pc := s.gb.Registers().PC
+	code := s.gb.ReadMemory(pc, 3)
+
+	instruction, ok := gb.Decode(code)
+	mnemonic := instruction.Mnemonic
+	if !ok || !(strings.HasPrefix(mnemonic, "CALL") || strings.HasPrefix(mnemonic, "RST")) {
+		s.gb.Step()
+		s.stoppedAfter("step")
+		return nil
+	}
+
+	s.runTo(pc + uint16(instruction.Size()))
+	return nil
+}
+
+// runs until the innermost call returns
+func (s *session) stepOut() error {
+	frames := s.gb.CallStack()
+	if len(frames) == 0 {
+		return errors.New("no call to step out of")
+	}
+
+	s.runTo(frames[0].Return)
+	return nil
+}
+
+func (s *session) runTo(addr uint16) {
+	id := s.gb.AddBreakpoint(gb.Breakpoint{Kind: gb.BreakPC, Addr: addr})
+
+	s.mu.Lock()
+	if s.temps == nil {
+		s.temps = make(map[int]bool)
+	}
+	s.temps[id] = true
+	s.mu.Unlock()
+
+	s.after(s.gb.Resume)
+}
+
+type sourceBreakpointsArgs struct {
+	Breakpoints []json.RawMessage `json:"breakpoints"`
+}
+
+// roms have no line information, so source breakpoints never bind
+func (s *session) setSourceBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args sourceBreakpointsArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	bps := make([]map[string]interface{}, len(args.Breakpoints))
+	for i := range bps {
+		bps[i] = map[string]interface{}{
+			"verified": false,
+			"message":  "goboy has no source line information, use function or instruction breakpoints",
+		}
+	}
+
+	return map[string]interface{}{"breakpoints": bps}, nil
+}
+
+// a function or instruction breakpoint, as the client asks for it
+type breakpointArgs struct {
+	Name string `json:"name"`
+
+	InstructionReference string `json:"instructionReference"`
+	Offset               int    `json:"offset"`
+
+	Condition    string `json:"condition"`
+	HitCondition string `json:"hitCondition"`
+	LogMessage   string `json:"logMessage"`
+}
+
+type breakpointsArgs struct {
+	Breakpoints []breakpointArgs `json:"breakpoints"`
+}
+
+func (s *session) setFunctionBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args breakpointsArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	return s.replaceBreakpoints(&s.functionBps, args.Breakpoints), nil
+}
+
+func (s *session) setInstructionBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args breakpointsArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	for i, bp := range args.Breakpoints {
+		args.Breakpoints[i].Name = bp.InstructionReference
+		if addr, ok := parseReference(bp.InstructionReference); ok {
+			args.Breakpoints[i].Name = fmt.Sprintf("$%04x", addr+uint16(bp.Offset))
+		}
+	}
+
+	return s.replaceBreakpoints(&s.instructionBps, args.Breakpoints), nil
+}
+
+// clears the breakpoints in *ids, then sets one for each of requested,
+// named by address or symbol
+func (s *session) replaceBreakpoints(ids *[]int, requested []breakpointArgs) interface{} {
+	s.mu.Lock()
+	old := *ids
+	*ids = nil
+	s.mu.Unlock()
+
+	for _, id := range old {
+		s.gb.RemoveBreakpoint(id)
+	}
+
+	bps := make([]map[string]interface{}, 0, len(requested))
+	for _, args := range requested {
+		bp, err := s.breakpoint(args)
+		if err != nil {
+			bps = append(bps, map[string]interface{}{"verified": false, "message": err.Error()})
+			continue
+		}
+
+		id := s.gb.AddBreakpoint(bp)
+
+		s.mu.Lock()
+		if s.clientIDs == nil {
+			s.clientIDs = make(map[int]int)
+		}
+		s.nextID++
+		clientID := s.nextID
+		s.clientIDs[id] = clientID
+		*ids = append(*ids, id)
+		s.mu.Unlock()
+
+		bps = append(bps, map[string]interface{}{
+			"id":                   clientID,
+			"verified":             true,
+			"instructionReference": reference(bp.Addr),
+		})
+	}
+
+	return map[string]interface{}{"breakpoints": bps}
+}
+
+func (s *session) breakpoint(args breakpointArgs) (gb.Breakpoint, error) {
+	addr, err := s.resolve(args.Name)
+	if err != nil {
+		return gb.Breakpoint{}, err
+	}
+
+	bp := gb.Breakpoint{Kind: gb.BreakPC, Addr: addr}
+
+	if args.Condition != "" {
+		if bp.Expr, err = expr.Compile(args.Condition, s.symbols.Addr); err != nil {
+			return gb.Breakpoint{}, err
+		}
+	}
+
+	if args.HitCondition != "" {
+		if bp.Ignore, err = parseHitCondition(args.HitCondition); err != nil {
+			return gb.Breakpoint{}, err
+		}
+	}
+
+	if args.LogMessage != "" {
+		if bp.Message, err = expr.CompileTemplate(args.LogMessage, s.symbols.Addr); err != nil {
+			return gb.Breakpoint{}, err
+		}
+		bp.Log = s.logPoint
+	}
+
+	return bp, nil
+}
+
+// "n", ">= n" or "> n", how many hits to let pass
+func parseHitCondition(cond string) (int, error) {
+	count := strings.TrimSpace(cond)
+	after := false
+
+	switch {
+	case strings.HasPrefix(count, ">="):
+		count = count[2:]
+	case strings.HasPrefix(count, ">"):
+		count, after = count[1:], true
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("hit condition %q isn't n, >= n or > n", cond)
+	}
+
+	if after || n == 0 {
+		return n, nil
+	}
+	return n - 1, nil
+}
+
+// runs on the emulation goroutine
+func (s *session) logPoint(id int, msg string) {
+	s.t.event("output", map[string]interface{}{"category": "console", "output": msg + "\n"})
+}
+
+type evaluateArgs struct {
+	Expression string `json:"expression"`
+}
+
+func (s *session) evaluate(raw json.RawMessage) (interface{}, error) {
+	var args evaluateArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	e, err := expr.Compile(args.Expression, s.symbols.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	n := s.gb.Eval(e)
+	return map[string]interface{}{
+		"result":             fmt.Sprintf("%s (%d)", expr.Format(n), n),
+		"variablesReference": 0,
+	}, nil
+}
+
+// an address or symbol, which has to be in a bank that's switched in
+func (s *session) resolve(name string) (uint16, error) {
+	sym, err := s.symbols.Resolve(name)
+	if err != nil {
+		return 0, err
+	}
+
+	if bank := symbols.BankAt(sym.Addr, s.gb.RomBank()); sym.Bank != bank {
+		return 0, fmt.Errorf("%s is in bank %d, but bank %d is switched in", name, sym.Bank, bank)
+	}
+
+	return sym.Addr, nil
+}
+
+func (s *session) describe(addr uint16) string {
+	return s.symbols.Describe(symbols.BankAt(addr, s.gb.RomBank()), addr)
+}
+
+// the current instruction, then the call that led to each frame
+func (s *session) stackTrace() interface{} {
+	pc := s.gb.Registers().PC
+	calls := s.gb.CallStack()
+
+	frames := []map[string]interface{}{s.frame(0, pc)}
+	for i, call := range calls {
+		frames = append(frames, s.frame(i+1, call.Call))
+	}
+
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+func (s *session) frame(id int, addr uint16) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                          id,
+		"name":                        s.describe(addr),
+		"line":                        0,
+		"column":                      0,
+		"instructionPointerReference": reference(addr),
+	}
+}
+
+type variablesArgs struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+func (s *session) variables(raw json.RawMessage) (interface{}, error) {
+	var args variablesArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	var vars []variable
+	switch args.VariablesReference {
+	case refRegisters:
+		r := s.gb.Registers()
+		byte8 := func(name string, b byte) variable { return variable{Name: name, Value: fmt.Sprintf("$%02x", b)} }
+		word := func(name string, w uint16) variable {
+			return variable{Name: name, Value: fmt.Sprintf("$%04x", w), MemoryReference: reference(w)}
+		}
+
+		vars = []variable{
+			byte8("a", r.A),
+			{Name: "f", Value: fmt.Sprintf("$%02x", r.F), VariablesReference: refFlags},
+			byte8("b", r.B), byte8("c", r.C), byte8("d", r.D), byte8("e", r.E), byte8("h", r.H), byte8("l", r.L),
+			word("bc", uint16(r.B)<<8|uint16(r.C)),
+			word("de", uint16(r.D)<<8|uint16(r.E)),
+			word("hl", uint16(r.H)<<8|uint16(r.L)),
+			word("sp", r.SP), word("pc", r.PC),
+		}
+	case refFlags:
+		f := s.gb.Registers().F
+		for i, name := range []string{"z", "n", "h", "c"} {
+			vars = append(vars, variable{Name: name, Value: strconv.Itoa(int(f>>(7-i)) & 1)})
+		}
+	case refIO:
+		for _, reg := range ioRegs {
+			b := s.gb.ReadMemory(reg.addr, 1)[0]
+			vars = append(vars, variable{Name: reg.name, Value: fmt.Sprintf("$%02x  %%%08b", b, b), MemoryReference: reference(reg.addr)})
+		}
+	default:
+		return nil, fmt.Errorf("no variables %d", args.VariablesReference)
+	}
+
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+type ioReg struct {
+	name string
+	addr uint16
+}
+
+var ioRegs = []ioReg{
+	{"p1", 0xff00}, {"div", 0xff04}, {"tima", 0xff05}, {"tma", 0xff06}, {"tac", 0xff07}, {"if", 0xff0f},
+	{"lcdc", 0xff40}, {"stat", 0xff41}, {"scy", 0xff42}, {"scx", 0xff43}, {"ly", 0xff44}, {"lyc", 0xff45},
+	{"dma", 0xff46}, {"bgp", 0xff47}, {"obp0", 0xff48}, {"obp1", 0xff49}, {"wy", 0xff4a}, {"wx", 0xff4b},
+	{"ie", 0xffff},
+}
+
+type readMemoryArgs struct {
+	MemoryReference string `json:"memoryReference"`
+	Offset          int    `json:"offset"`
+	Count           int    `json:"count"`
+}
+
+func (s *session) readMemory(raw json.RawMessage) (interface{}, error) {
+	var args readMemoryArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	base, ok := parseReference(args.MemoryReference)
+	if !ok {
+		return nil, fmt.Errorf("bad memory reference %q", args.MemoryReference)
+	}
+
+	addr := int(base) + args.Offset
+	count := args.Count
+	if addr < 0 || addr > 0xffff {
+		return map[string]interface{}{"address": reference(base), "unreadableBytes": count}, nil
+	}
+
+	// the address space ends at 0xffff, nothing wraps
+	unreadable := 0
+	if addr+count > 0x10000 {
+		unreadable = addr + count - 0x10000
+		count -= unreadable
+	}
+
+	data := s.gb.ReadMemory(uint16(addr), count)
+	return map[string]interface{}{
+		"address":         reference(uint16(addr)),
+		"data":            base64.StdEncoding.EncodeToString(data),
+		"unreadableBytes": unreadable,
+	}, nil
+}
+
+type writeMemoryArgs struct {
+	MemoryReference string `json:"memoryReference"`
+	Offset          int    `json:"offset"`
+	Data            string `json:"data"`
+}
+
+func (s *session) writeMemory(raw json.RawMessage) (interface{}, error) {
+	var args writeMemoryArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	base, ok := parseReference(args.MemoryReference)
+	if !ok {
+		return nil, fmt.Errorf("bad memory reference %q", args.MemoryReference)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(args.Data)
+	addr := int(base) + args.Offset
+	if err != nil || addr < 0 || addr+len(data) > 0x10000 {
+		return nil, errors.New("write is outside the address space")
+	}
+
+	s.gb.WriteMemory(uint16(addr), data)
+	return map[string]interface{}{"bytesWritten": len(data)}, nil
+}
+
+type disassembleArgs struct {
+	MemoryReference   string `json:"memoryReference"`
+	Offset            int    `json:"offset"`
+	InstructionOffset int    `json:"instructionOffset"`
+	InstructionCount  int    `json:"instructionCount"`
+}
+
+func (s *session) disassemble(raw json.RawMessage) (interface{}, error) {
+	var args disassembleArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	base, ok := parseReference(args.MemoryReference)
+	if !ok {
+		return nil, fmt.Errorf("bad memory reference %q", args.MemoryReference)
+	}
+
+	addr := uint16(int(base) + args.Offset)
+	start := s.instructionsBefore(addr, -args.InstructionOffset)
+
+	instructions := make([]map[string]interface{}, 0, args.InstructionCount)
+	for i := 0; i < args.InstructionCount; i++ {
+		code := s.gb.ReadMemory(start, 3)
+		text, size := disasm.Labeled(code, start, s.symbols, s.gb.RomBank())
+
+		instruction := map[string]interface{}{
+			"address":          reference(start),
+			"instructionBytes": fmt.Sprintf("% x", code[:size]),
+			"instruction":      text,
+		}
+		if name, ok := s.symbols.At(symbols.BankAt(start, s.gb.RomBank()), start); ok {
+			instruction["symbol"] = name
+		}
+
+		instructions = append(instructions, instruction)
+		start += uint16(size)
+	}
+
+	return map[string]interface{}{"instructions": instructions}, nil
+}
+
+// the address n instructions before addr, or after it for negative n.
+// instructions vary in length, so this looks for a starting point that
+// decodes into addr, which is usually, but not always, the real one
+func (s *session) instructionsBefore(addr uint16, n int) uint16 {
+	if n <= 0 {
+		for ; n < 0; n++ {
+			_, size := disasm.Instruction(s.gb.ReadMemory(addr, 3), addr)
+			addr += uint16(size)
+		}
+		return addr
+	}
+
+	back := 3 * n
+	if back > int(addr) {
+		back = int(addr)
+	}
+
+	from := addr - uint16(back)
+	code := s.gb.ReadMemory(from, back+3)
+
+	for skip := 0; skip < back; skip++ {
+		var starts []uint16
+		for pc := skip; pc < back; {
+			starts = append(starts, from+uint16(pc))
+			_, size := disasm.Instruction(code[pc:], from+uint16(pc))
+			pc += size
+			if pc == back && len(starts) >= n {
+				return starts[len(starts)-n]
+			}
+		}
+	}
+
+	// nothing lines up, so pretend every instruction is a byte
+	return addr - uint16(back/3)
+}
+
+// memory references are addresses, like 0x0150
+func reference(addr uint16) string {
+	return fmt.Sprintf("0x%04x", addr)
+}
+
+func parseReference(ref string) (uint16, bool) {
+	addr, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(ref), "0x"), 16, 16)
+	return uint16(addr), err == nil
+}
@@ -0,0 +1,648 @@
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/justinawrey/goboy/expr"
+	"github.com/justinawrey/goboy/gb"
+)
+
+var errQuit = errors.New("quit")
+
+type command struct {
+	names []string
+	usage string
+	help  string
+	run   func(d *Debugger, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{[]string{"break", "b"}, "break <addr|symbol>|op <opcode>|badret [if <expr>]", "break at an address, on an opcode, on a corrupt return, or when expr holds", cmdBreak},
+		{[]string{"watch", "w"}, "watch r|w|rw|x <addr>[-<end>] [== v|!= v|changes] [if <expr>]", "break on reads, writes or execution of an address range", cmdWatch},
+		{[]string{"trace", "t"}, "trace <addr> <message>", "log message at addr without stopping, {expr}s filled in", cmdTrace},
+		{[]string{"cond"}, "cond <id> [expr]", "set or clear a breakpoint's condition", cmdCond},
+		{[]string{"ignore"}, "ignore <id> <n>", "let a breakpoint pass its next n hits", cmdIgnore},
+		{[]string{"delete", "d"}, "delete <id>", "remove a breakpoint", cmdDelete},
+		{[]string{"breakpoints", "bl"}, "breakpoints", "list breakpoints", cmdBreakpoints},
+		{[]string{"step", "s"}, "step [n]", "execute n instructions", cmdStep},
+		{[]string{"next", "n"}, "next", "step over calls and rsts", cmdNext},
+		{[]string{"continue", "c"}, "continue", "run until a breakpoint or ctrl-c", cmdContinue},
+		{[]string{"frame", "f"}, "frame <n>", "run until frame n", cmdFrame},
+		{[]string{"reverse-step", "rs"}, "reverse-step [n]", "undo the last n instructions", cmdReverseStep},
+		{[]string{"reverse-continue", "rc"}, "reverse-continue", "go back to the last breakpoint hit", cmdReverseContinue},
+		{[]string{"last-write", "lw"}, "last-write <addr>", "find the last write to addr in the history", cmdLastWrite},
+		{[]string{"backtrace", "bt"}, "backtrace", "list the calls that led here", cmdBacktrace},
+		{[]string{"regs", "r"}, "regs", "show registers and flags", cmdRegs},
+		{[]string{"print", "p"}, "print <expr>", "evaluate an expression", cmdPrint},
+		{[]string{"x"}, "x <addr> [len]", "hexdump memory", cmdExamine},
+		{[]string{"poke"}, "poke <addr> <byte>...", "write bytes to memory", cmdPoke},
+		{[]string{"disasm", "di"}, "disasm [addr] [n]", "disassemble n instructions", cmdDisasm},
+		{[]string{"info", "i"}, "info ppu|timer|int", "show io registers", cmdInfo},
+		{[]string{"history"}, "history", "list history, rerun with !n or !!", cmdHistory},
+		{[]string{"help", "h"}, "help", "list commands", cmdHelp},
+		{[]string{"quit", "q"}, "quit", "exit the debugger", cmdQuit},
+	}
+}
+
+func lookup(name string) (command, bool) {
+	for _, cmd := range commands {
+		for _, n := range cmd.names {
+			if n == name {
+				return cmd, true
+			}
+		}
+	}
+
+	return command{}, false
+}
+
+func usage(name string) error {
+	cmd, _ := lookup(name)
+	return fmt.Errorf("usage: %s", cmd.usage)
+}
+
+func cmdBreak(d *Debugger, args []string) error {
+	args, cond, err := d.splitCondition(args)
+	if err != nil {
+		return err
+	}
+
+	bp := gb.Breakpoint{Expr: cond}
+	switch {
+	case len(args) == 0 && cond != nil:
+		bp.Kind = gb.BreakExpr
+	case len(args) == 2 && args[0] == "op":
+		bp.Kind = gb.BreakOpcode
+		if bp.Opcode, err = parseWord(args[1]); err != nil {
+			return err
+		}
+	case len(args) == 1 && args[0] == "badret":
+		bp.Kind = gb.BreakBadReturn
+	case len(args) == 1:
+		bp.Kind = gb.BreakPC
+		if bp.Addr, err = d.parseAddr(args[0]); err != nil {
+			return err
+		}
+	default:
+		return usage("break")
+	}
+
+	id := d.gb.AddBreakpoint(bp)
+	fmt.Fprintf(d.out, "breakpoint %d on %s\n", id, d.describeBreakpoint(bp))
+	return nil
+}
+
+// splits "... if <expr>" into the args before the if and the expression
+func (d *Debugger) splitCondition(args []string) ([]string, *expr.Expr, error) {
+	for i, arg := range args {
+		if arg != "if" {
+			continue
+		}
+
+		cond, err := d.compile(strings.Join(args[i+1:], " "))
+		return args[:i], cond, err
+	}
+
+	return args, nil, nil
+}
+
+func (d *Debugger) compile(src string) (*expr.Expr, error) {
+	return expr.Compile(src, d.syms.Addr)
+}
+
+var watchKinds = map[string]gb.BreakKind{
+	"r":  gb.BreakRead,
+	"w":  gb.BreakWrite,
+	"rw": gb.BreakAccess,
+	"x":  gb.BreakPC,
+}
+
+func cmdWatch(d *Debugger, args []string) error {
+	args, cond, err := d.splitCondition(args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 2 {
+		return usage("watch")
+	}
+
+	kind, ok := watchKinds[args[0]]
+	if !ok {
+		return usage("watch")
+	}
+
+	bp := gb.Breakpoint{Kind: kind, Expr: cond}
+
+	fromStr, toStr, isRange := strings.Cut(args[1], "-")
+	if bp.Addr, err = d.parseAddr(fromStr); err != nil {
+		return err
+	}
+	if isRange {
+		if bp.End, err = d.parseAddr(toStr); err != nil {
+			return err
+		}
+		if bp.End < bp.Addr {
+			return fmt.Errorf("range %s ends before it starts", args[1])
+		}
+	}
+
+	switch cond := args[2:]; {
+	case len(cond) == 0:
+	case len(cond) == 1 && cond[0] == "changes":
+		if kind == gb.BreakRead || kind == gb.BreakPC {
+			return errors.New("only writes change memory")
+		}
+		bp.Cond = gb.ValueChanges
+	case len(cond) == 2 && (cond[0] == "==" || cond[0] == "!="):
+		if bp.Value, err = parseByte(cond[1]); err != nil {
+			return err
+		}
+		bp.Cond = gb.ValueEquals
+		if cond[0] == "!=" {
+			bp.Cond = gb.ValueNotEquals
+		}
+	default:
+		return usage("watch")
+	}
+
+	id := d.gb.AddBreakpoint(bp)
+	fmt.Fprintf(d.out, "watchpoint %d on %s\n", id, d.describeBreakpoint(bp))
+	return nil
+}
+
+func cmdTrace(d *Debugger, args []string) error {
+	if len(args) < 2 {
+		return usage("trace")
+	}
+
+	addr, err := d.parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+
+	msg, err := expr.CompileTemplate(strings.Join(args[1:], " "), d.syms.Addr)
+	if err != nil {
+		return err
+	}
+
+	bp := gb.Breakpoint{Kind: gb.BreakPC, Addr: addr, Message: msg, Log: d.logTrace}
+	id := d.gb.AddBreakpoint(bp)
+	fmt.Fprintf(d.out, "tracepoint %d on %s\n", id, d.describeBreakpoint(bp))
+	return nil
+}
+
+// runs on the emulation goroutine, between prompts while it's running
+func (d *Debugger) logTrace(id int, msg string) {
+	fmt.Fprintf(d.out, "[%d] %s\n", id, msg)
+}
+
+func cmdCond(d *Debugger, args []string) error {
+	if len(args) < 1 {
+		return usage("cond")
+	}
+
+	id, bp, err := d.breakpoint(args[0])
+	if err != nil {
+		return err
+	}
+
+	bp.Expr = nil
+	if len(args) > 1 {
+		if bp.Expr, err = d.compile(strings.Join(args[1:], " ")); err != nil {
+			return err
+		}
+	}
+
+	d.gb.UpdateBreakpoint(id, bp)
+	return nil
+}
+
+func cmdIgnore(d *Debugger, args []string) error {
+	if len(args) != 2 {
+		return usage("ignore")
+	}
+
+	id, bp, err := d.breakpoint(args[0])
+	if err != nil {
+		return err
+	}
+
+	n, err := parseCount(args[1])
+	if err != nil {
+		return err
+	}
+
+	bp.Ignore = bp.Hits + n
+	d.gb.UpdateBreakpoint(id, bp)
+	return nil
+}
+
+func (d *Debugger) breakpoint(idStr string) (int, gb.Breakpoint, error) {
+	id, err := parseCount(idStr)
+	if err != nil {
+		return 0, gb.Breakpoint{}, err
+	}
+
+	bp, ok := d.gb.Breakpoints()[id]
+	if !ok {
+		return 0, gb.Breakpoint{}, fmt.Errorf("no breakpoint %d", id)
+	}
+
+	return id, bp, nil
+}
+
+func cmdDelete(d *Debugger, args []string) error {
+	if len(args) != 1 {
+		return usage("delete")
+	}
+
+	id, err := parseCount(args[0])
+	if err != nil {
+		return err
+	}
+
+	if !d.gb.RemoveBreakpoint(id) {
+		return fmt.Errorf("no breakpoint %d", id)
+	}
+
+	return nil
+}
+
+func cmdBreakpoints(d *Debugger, args []string) error {
+	bps := d.gb.Breakpoints()
+	if len(bps) == 0 {
+		fmt.Fprintln(d.out, "no breakpoints")
+		return nil
+	}
+
+	ids := make([]int, 0, len(bps))
+	for id := range bps {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		fmt.Fprintf(d.out, "%3d  %s\n", id, d.describeBreakpoint(bps[id]))
+	}
+
+	return nil
+}
+
+func (d *Debugger) describeBreakpoint(bp gb.Breakpoint) string {
+	var what string
+	switch bp.Kind {
+	case gb.BreakPC:
+		what = "pc"
+	case gb.BreakOpcode:
+		what = fmt.Sprintf("opcode %02x", bp.Opcode)
+	case gb.BreakExpr:
+		what = "any pc"
+	case gb.BreakBadReturn:
+		what = "bad return"
+	case gb.BreakRead:
+		what = "read"
+	case gb.BreakWrite:
+		what = "write"
+	case gb.BreakAccess:
+		what = "access"
+	default:
+		return "?"
+	}
+
+	if bp.Kind != gb.BreakOpcode && bp.Kind != gb.BreakExpr && bp.Kind != gb.BreakBadReturn {
+		what += " " + d.location(bp.Addr)
+	}
+	if bp.End > bp.Addr {
+		what += " - " + d.location(bp.End)
+	}
+
+	switch bp.Cond {
+	case gb.ValueEquals:
+		what += fmt.Sprintf(" == %02x", bp.Value)
+	case gb.ValueNotEquals:
+		what += fmt.Sprintf(" != %02x", bp.Value)
+	case gb.ValueChanges:
+		what += " changes"
+	}
+
+	if bp.Expr != nil {
+		what += " if " + bp.Expr.String()
+	}
+	if bp.Message != nil {
+		what += fmt.Sprintf(" trace %q", bp.Message)
+	}
+	if bp.Ignore > bp.Hits {
+		what += fmt.Sprintf(", ignoring %d more", bp.Ignore-bp.Hits)
+	}
+	if bp.Hits > 0 {
+		what += fmt.Sprintf(", hit %d times", bp.Hits)
+	}
+
+	return what
+}
+
+// an address, with the symbol it's in if there is one
+func (d *Debugger) location(addr uint16) string {
+	if _, ok := d.syms.Nearest(d.bank(addr), addr); ok {
+		return fmt.Sprintf("%04x (%s)", addr, d.describe(addr))
+	}
+
+	return fmt.Sprintf("%04x", addr)
+}
+
+func cmdStep(d *Debugger, args []string) error {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		if n, err = parseCount(args[0]); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		d.gb.Step()
+	}
+
+	d.where()
+	return nil
+}
+
+// runs to the instruction after a call, or steps anything else
+func cmdNext(d *Debugger, args []string) error {
+	pc := d.gb.Registers().PC
+	instruction, ok := gb.Decode(d.gb.ReadMemory(pc, 2))
+
+	if !ok || !(strings.HasPrefix(instruction.Mnemonic, "CALL") || strings.HasPrefix(instruction.Mnemonic, "RST")) {
+		return cmdStep(d, nil)
+	}
+
+	ret := pc + uint16(instruction.Size())
+	id := d.gb.AddBreakpoint(gb.Breakpoint{Kind: gb.BreakPC, Addr: ret})
+	defer d.gb.RemoveBreakpoint(id)
+
+	return d.cont()
+}
+
+func cmdContinue(d *Debugger, args []string) error {
+	return d.cont()
+}
+
+func cmdFrame(d *Debugger, args []string) error {
+	if len(args) != 1 {
+		return usage("frame")
+	}
+
+	frame, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if frame <= d.gb.Frame() {
+		return fmt.Errorf("already at frame %d", d.gb.Frame())
+	}
+
+	return d.run(func() { d.gb.RunToFrame(frame) })
+}
+
+func cmdReverseStep(d *Debugger, args []string) error {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		if n, err = parseCount(args[0]); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		if err := d.gb.ReverseStep(); err != nil {
+			d.where()
+			return err
+		}
+	}
+
+	d.where()
+	return nil
+}
+
+func cmdReverseContinue(d *Debugger, args []string) error {
+	if err := d.gb.ReverseContinue(); err != nil {
+		return err
+	}
+
+	select {
+	case stop := <-d.gb.Stops():
+		d.printStop(stop)
+	default:
+	}
+
+	d.where()
+	return nil
+}
+
+func cmdLastWrite(d *Debugger, args []string) error {
+	if len(args) != 1 {
+		return usage("last-write")
+	}
+
+	addr, err := d.parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+
+	w, ok, err := d.gb.LastWrite(addr)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no writes to %s in the history", d.location(addr))
+	}
+
+	fmt.Fprintf(d.out, "frame %d, %d instructions ago\n", w.Frame, d.gb.Instructions()-w.Instructions)
+	d.printAccess(w.Access)
+	return nil
+}
+
+// the calls come from the shadow stack, so they stay right when a
+// rom has pushed other things on top of their return addresses
+func cmdBacktrace(d *Debugger, args []string) error {
+	pc := d.gb.Registers().PC
+	fmt.Fprintf(d.out, "#0  %04x  %s\n", pc, d.describe(pc))
+
+	for i, frame := range d.gb.CallStack() {
+		fmt.Fprintf(d.out, "#%-2d %04x  %s, called from %s, sp %04x\n", i+1, frame.Return, d.describe(frame.Return), d.location(frame.Call), frame.SP)
+	}
+
+	if ret, ok := d.gb.LastBadReturn(); ok {
+		fmt.Fprint(d.out, "last corrupt return: ")
+		d.printBadReturn(ret)
+	}
+
+	return nil
+}
+
+func cmdRegs(d *Debugger, args []string) error {
+	r := d.gb.Registers()
+
+	flags := []byte("----")
+	for i, f := range "ZNHC" {
+		if r.F&(0x80>>i) != 0 {
+			flags[i] = byte(f)
+		}
+	}
+
+	fmt.Fprintf(d.out, "a  %02x   f  %02x [%s]\n", r.A, r.F, flags)
+	fmt.Fprintf(d.out, "bc %02x%02x  de %02x%02x  hl %02x%02x\n", r.B, r.C, r.D, r.E, r.H, r.L)
+	fmt.Fprintf(d.out, "sp %04x  pc %04x  frame %d\n", r.SP, r.PC, d.gb.Frame())
+	return nil
+}
+
+func cmdPrint(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		return usage("print")
+	}
+
+	e, err := d.compile(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	n := d.gb.Eval(e)
+	fmt.Fprintf(d.out, "%s (%d)\n", expr.Format(n), n)
+	return nil
+}
+
+func cmdExamine(d *Debugger, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usage("x")
+	}
+
+	addr, err := d.parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+
+	length := 64
+	if len(args) > 1 {
+		if length, err = parseCount(args[1]); err != nil {
+			return err
+		}
+	}
+
+	hexdump(d.out, addr, d.gb.ReadMemory(addr, length))
+	return nil
+}
+
+func cmdPoke(d *Debugger, args []string) error {
+	if len(args) < 2 {
+		return usage("poke")
+	}
+
+	addr, err := d.parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		if data[i], err = parseByte(arg); err != nil {
+			return err
+		}
+	}
+
+	d.gb.WriteMemory(addr, data)
+	return nil
+}
+
+func cmdDisasm(d *Debugger, args []string) error {
+	addr := d.gb.Registers().PC
+	n := 10
+
+	var err error
+	if len(args) > 0 {
+		if addr, err = d.parseAddr(args[0]); err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		if n, err = parseCount(args[1]); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		line, size := d.disassemble(addr)
+		fmt.Fprintln(d.out, "   "+line)
+		addr += uint16(size)
+	}
+
+	return nil
+}
+
+type ioReg struct {
+	name string
+	addr uint16
+}
+
+var ioRegs = map[string][]ioReg{
+	"ppu": {
+		{"lcdc", 0xff40}, {"stat", 0xff41}, {"scy", 0xff42}, {"scx", 0xff43},
+		{"ly", 0xff44}, {"lyc", 0xff45}, {"dma", 0xff46}, {"bgp", 0xff47},
+		{"obp0", 0xff48}, {"obp1", 0xff49}, {"wy", 0xff4a}, {"wx", 0xff4b},
+	},
+	"timer": {
+		{"div", 0xff04}, {"tima", 0xff05}, {"tma", 0xff06}, {"tac", 0xff07},
+	},
+	"int": {
+		{"if", 0xff0f}, {"ie", 0xffff},
+	},
+}
+
+func cmdInfo(d *Debugger, args []string) error {
+	if len(args) != 1 {
+		return usage("info")
+	}
+
+	regs, ok := ioRegs[args[0]]
+	if !ok {
+		return usage("info")
+	}
+
+	for _, reg := range regs {
+		b := d.gb.ReadMemory(reg.addr, 1)[0]
+		fmt.Fprintf(d.out, "%-5s %04x  %02x  %08b\n", reg.name, reg.addr, b, b)
+	}
+
+	return nil
+}
+
+func cmdHistory(d *Debugger, args []string) error {
+	for i, line := range d.history {
+		fmt.Fprintf(d.out, "%4d  %s\n", i+1, line)
+	}
+
+	return nil
+}
+
+func cmdHelp(d *Debugger, args []string) error {
+	for _, cmd := range commands {
+		fmt.Fprintf(d.out, "%-34s %s\n", cmd.usage, cmd.help)
+	}
+
+	fmt.Fprintln(d.out, "numbers are hex, with an optional $ or 0x, except counts and frames")
+	fmt.Fprintln(d.out, "addresses can also be symbols from the rom's .sym or .map file")
+	fmt.Fprintln(d.out, "expressions use registers, flags zf nf hf cf, ly, mode, frame, [addr] and word[addr],")
+	fmt.Fprintln(d.out, "with c operators and decimal numbers unless written $ff or 0xff")
+	return nil
+}
+
+func cmdQuit(d *Debugger, args []string) error {
+	return errQuit
+}
@@ -0,0 +1,274 @@
+package debugger
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/justinawrey/goboy/gb"
+	"github.com/justinawrey/goboy/symbols"
+)
+
+// lines of history kept in historyFile
+const maxHistory = 1000
+
+// Debugger is an interactive command line debugger for a paused Gb
+type Debugger struct {
+	gb  *gb.Gb
+	in  *bufio.Scanner
+	out io.Writer
+
+	// from the rom's .sym or .map file, if it has one
+	syms *symbols.Table
+
+	history     []string
+	historyFile string
+
+	// Run's result, once it returns
+	done chan error
+}
+
+// snapshotting every frame keeps reverse steps quick
+const historyGranularity = 1
+
+// Run loads rom into a headless Gb and debugs it on stdin / stdout,
+// keeping history seconds of it to go back through
+func Run(rom string, history float64) error {
+	syms, err := symbols.ForRom(rom)
+	if err != nil {
+		return err
+	}
+
+	gameboy := gb.NewGb()
+	gameboy.LoadCartridge(rom)
+	gameboy.EnableRewind(history, historyGranularity)
+	gameboy.EnableCallStack(true)
+	gameboy.SetUnimplementedPolicy(gb.UnimplementedPause)
+
+	// nothing's watching the screen, so continuing needn't wait for it
+	gameboy.SetSpeed(0)
+	gameboy.Pause()
+
+	// boot now, so the first prompt doesn't race Run booting it
+	gameboy.Reset(false)
+
+	d := &Debugger{
+		gb:   gameboy,
+		in:   bufio.NewScanner(os.Stdin),
+		out:  os.Stdout,
+		syms: syms,
+		done: make(chan error, 1),
+	}
+
+	if n := syms.Len(); n > 0 {
+		fmt.Fprintf(d.out, "loaded %d symbols\n", n)
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		d.historyFile = filepath.Join(home, ".goboy_history")
+		d.loadHistory()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { d.done <- gameboy.Run(ctx) }()
+
+	if err := d.repl(); err != nil {
+		return err
+	}
+
+	cancel()
+	if err := <-d.done; !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	return nil
+}
+
+func (d *Debugger) repl() error {
+	d.where()
+
+	last := ""
+	for {
+		fmt.Fprint(d.out, "(goboy) ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			return d.in.Err()
+		}
+
+		line := strings.TrimSpace(d.in.Text())
+
+		// an empty line repeats the last command
+		if line == "" {
+			line = last
+		}
+		if line == "" {
+			continue
+		}
+
+		line, err := d.expand(line)
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+			continue
+		}
+
+		if line != last {
+			d.record(line)
+		}
+		last = line
+
+		fields := strings.Fields(line)
+		cmd, ok := lookup(fields[0])
+		if !ok {
+			fmt.Fprintf(d.out, "unknown command %q, try help\n", fields[0])
+			continue
+		}
+
+		err = cmd.run(d, fields[1:])
+		if errors.Is(err, errQuit) {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+		}
+	}
+}
+
+// resumes emulation and waits for a breakpoint or ctrl-c
+func (d *Debugger) cont() error {
+	return d.run(d.gb.Resume)
+}
+
+// calls resume, then waits for emulation to stop
+func (d *Debugger) run(resume func()) error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	resume()
+
+	select {
+	case stop := <-d.gb.Stops():
+		d.printStop(stop)
+	case <-interrupt:
+		d.gb.Pause()
+		fmt.Fprintln(d.out, "interrupted")
+	case err := <-d.done:
+		if err == nil {
+			err = errors.New("emulation stopped")
+		}
+		return err
+	}
+
+	d.where()
+	return nil
+}
+
+func (d *Debugger) printStop(stop gb.Stop) {
+	switch stop.Reason {
+	case gb.StopBreakpoint:
+		fmt.Fprintf(d.out, "breakpoint %d at frame %d\n", stop.Breakpoint, stop.Frame)
+		if a := stop.Access; a != nil {
+			d.printAccess(*a)
+		}
+		if ret := stop.BadReturn; ret != nil {
+			d.printBadReturn(*ret)
+		}
+	case gb.StopFrame:
+		fmt.Fprintf(d.out, "reached frame %d\n", stop.Frame)
+	case gb.StopHistoryStart:
+		fmt.Fprintf(d.out, "reached the start of the history at frame %d\n", stop.Frame)
+	case gb.StopUnimplemented:
+		in := stop.Unimplemented
+		fmt.Fprintf(d.out, "%s at %s isn't implemented, continuing runs it as a no-op\n", in.Mnemonic, d.location(in.PC))
+	}
+}
+
+// prints what the instruction that hit a watchpoint did
+func (d *Debugger) printAccess(a gb.Access) {
+	switch a.Kind {
+	case gb.AccessRead:
+		fmt.Fprintf(d.out, "read %02x from %s\n", a.New, d.location(a.Addr))
+	case gb.AccessWrite:
+		fmt.Fprintf(d.out, "wrote %s: %02x -> %02x\n", d.location(a.Addr), a.Old, a.New)
+	}
+
+	line, _ := d.disassemble(a.PC)
+	fmt.Fprintln(d.out, "by "+line)
+}
+
+func (d *Debugger) printBadReturn(ret gb.BadReturn) {
+	fmt.Fprintf(d.out, "%s returned to %s, not %s after the call at %s\n",
+		d.location(ret.PC), d.location(ret.Target), d.location(ret.Expected.Return), d.location(ret.Expected.Call))
+}
+
+// prints the instruction about to execute
+func (d *Debugger) where() {
+	pc := d.gb.Registers().PC
+	line, _ := d.disassemble(pc)
+	fmt.Fprintln(d.out, "=> "+line)
+}
+
+// handles !! and !n
+func (d *Debugger) expand(line string) (string, error) {
+	if !strings.HasPrefix(line, "!") {
+		return line, nil
+	}
+
+	if len(d.history) == 0 {
+		return "", errors.New("no history")
+	}
+
+	if line == "!!" {
+		return d.history[len(d.history)-1], nil
+	}
+
+	n, err := parseCount(line[1:])
+	if err != nil || n < 1 || n > len(d.history) {
+		return "", fmt.Errorf("no history entry %s", line[1:])
+	}
+
+	return d.history[n-1], nil
+}
+
+func (d *Debugger) record(line string) {
+	d.history = append(d.history, line)
+
+	if d.historyFile == "" {
+		return
+	}
+
+	f, err := os.OpenFile(d.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, line)
+}
+
+func (d *Debugger) loadHistory() {
+	f, err := os.Open(d.historyFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		d.history = append(d.history, scanner.Text())
+	}
+
+	if len(d.history) <= maxHistory {
+		return
+	}
+
+	d.history = d.history[len(d.history)-maxHistory:]
+	os.WriteFile(d.historyFile, []byte(strings.Join(d.history, "\n")+"\n"), 0600)
+}
@@ -0,0 +1,103 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/justinawrey/goboy/disasm"
+	"github.com/justinawrey/goboy/symbols"
+)
+
+// the instruction at addr with its operands filled in, and its size
+func (d *Debugger) disassemble(addr uint16) (string, int) {
+	code := d.gb.ReadMemory(addr, 3)
+	text, size := disasm.Labeled(code, addr, d.syms, d.gb.RomBank())
+	line := fmt.Sprintf("%04x  %-8s  %s", addr, fmt.Sprintf("% x", code[:size]), text)
+
+	if _, ok := d.syms.Nearest(d.bank(addr), addr); ok {
+		line = fmt.Sprintf("%-40s ; %s", line, d.describe(addr))
+	}
+
+	return line, size
+}
+
+// the bank addr is in, as the symbols number them
+func (d *Debugger) bank(addr uint16) int {
+	return symbols.BankAt(addr, d.gb.RomBank())
+}
+
+func (d *Debugger) describe(addr uint16) string {
+	return d.syms.Describe(d.bank(addr), addr)
+}
+
+// addresses are hex numbers or symbols, which have to be in a bank
+// that's switched in
+func (d *Debugger) parseAddr(s string) (uint16, error) {
+	sym, err := d.syms.Resolve(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if bank := d.bank(sym.Addr); sym.Bank != bank {
+		return 0, fmt.Errorf("%s is in bank %d, but bank %d is switched in", s, sym.Bank, bank)
+	}
+
+	return sym.Addr, nil
+}
+
+func hexdump(w io.Writer, addr uint16, data []byte) {
+	for len(data) > 0 {
+		n := 16
+		if len(data) < n {
+			n = len(data)
+		}
+
+		line := data[:n]
+		data = data[n:]
+
+		ascii := make([]byte, n)
+		for i, b := range line {
+			ascii[i] = '.'
+			if b >= 0x20 && b < 0x7f {
+				ascii[i] = b
+			}
+		}
+
+		fmt.Fprintf(w, "%04x  %-47s  %s\n", addr, fmt.Sprintf("% x", line), ascii)
+		addr += uint16(n)
+	}
+}
+
+// numbers are hex, optionally written as $ff or 0xff
+func parseHex(s string, bits int) (uint64, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+
+	n, err := strconv.ParseUint(s, 16, bits)
+	if err != nil {
+		return 0, fmt.Errorf("bad number %q", s)
+	}
+
+	return n, nil
+}
+
+func parseWord(s string) (uint16, error) {
+	n, err := parseHex(s, 16)
+	return uint16(n), err
+}
+
+func parseByte(s string) (byte, error) {
+	n, err := parseHex(s, 8)
+	return byte(n), err
+}
+
+// counts are decimal
+func parseCount(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad count %q", s)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,211 @@
+package disasm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/justinawrey/goboy/gb"
+	"github.com/justinawrey/goboy/symbols"
+)
+
+const bankSize = 0x4000
+
+var ErrBank = errors.New("disasm: bank is outside the rom")
+
+// Line is one instruction, or a db for bytes that don't decode
+type Line struct {
+	Bank  int
+	Addr  uint16
+	Bytes []byte
+
+	// rgbds syntax
+	Text string
+
+	// label defined at Addr, if there's a symbol for it or anything
+	// in range jumps here
+	Label string
+}
+
+// Instruction disassembles the instruction at the start of code, which
+// lives at addr, and returns its size.  jump targets are left as addresses
+func Instruction(code []byte, addr uint16) (text string, size int) {
+	return instruction(code, addr, func(target uint16) string {
+		return fmt.Sprintf("$%04x", target)
+	})
+}
+
+// Labeled is Instruction with addresses named after syms, while romBank
+// is switched in
+func Labeled(code []byte, addr uint16, syms *symbols.Table, romBank int) (text string, size int) {
+	return instruction(code, addr, func(target uint16) string {
+		return syms.Describe(symbols.BankAt(target, romBank), target)
+	})
+}
+
+// Disassemble sweeps code from start to end, as loaded at origin in bank.
+// syms, which may be nil, names the labels
+func Disassemble(code []byte, bank int, origin uint16, syms *symbols.Table) []Line {
+	var lines []Line
+
+	for offset := 0; offset < len(code); {
+		addr := origin + uint16(offset)
+		_, size := Instruction(code[offset:], addr)
+		lines = append(lines, Line{Bank: bank, Addr: addr, Bytes: code[offset : offset+size]})
+		offset += size
+	}
+
+	// every instruction that is jumped to from within range gets a label
+	labels := make(map[uint16]string)
+	for _, line := range lines {
+		labels[line.Addr], _ = syms.At(symbols.BankAt(line.Addr, bank), line.Addr)
+	}
+
+	for _, line := range lines {
+		target, ok := jumpTarget(line.Bytes, line.Addr)
+		if name, inRange := labels[target]; ok && inRange && name == "" {
+			labels[target] = fmt.Sprintf("L%02x_%04x", bank, target)
+		}
+	}
+
+	label := func(target uint16) string {
+		if name := labels[target]; name != "" {
+			return name
+		}
+		return fmt.Sprintf("$%04x", target)
+	}
+
+	for i := range lines {
+		line := &lines[i]
+		line.Text, _ = instruction(line.Bytes, line.Addr, label)
+		line.Label = labels[line.Addr]
+	}
+
+	return lines
+}
+
+// Rom disassembles from through to, inclusive, of a bank of a rom file.
+// bank 0 is at 0x0000 - 0x3fff, the others are switched into 0x4000 - 0x7fff
+func Rom(rom []byte, bank int, from, to uint16, syms *symbols.Table) ([]Line, error) {
+	start := uint16(0)
+	if bank > 0 {
+		start = bankSize
+	}
+
+	if from < start || to >= start+bankSize || from > to {
+		return nil, fmt.Errorf("disasm: bank %d is at $%04x - $%04x", bank, start, start+bankSize-1)
+	}
+
+	offset := bank*bankSize + int(from-start)
+	end := offset + int(to-from) + 1
+
+	if offset >= len(rom) {
+		return nil, ErrBank
+	}
+	if end > len(rom) {
+		end = len(rom)
+	}
+
+	return Disassemble(rom[offset:end], bank, from, syms), nil
+}
+
+// Write writes lines as an rgbds section that assembles back to the same bytes
+func Write(w io.Writer, lines []Line) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	first := lines[0]
+	if first.Bank == 0 {
+		fmt.Fprintf(w, "SECTION \"rom0_%04x\", ROM0[$%04x]\n\n", first.Addr, first.Addr)
+	} else {
+		fmt.Fprintf(w, "SECTION \"romx_%02x_%04x\", ROMX[$%04x], BANK[%d]\n\n", first.Bank, first.Addr, first.Addr, first.Bank)
+	}
+
+	for _, line := range lines {
+		if line.Label != "" {
+			fmt.Fprintf(w, "%s:\n", line.Label)
+		}
+
+		_, err := fmt.Fprintf(w, "\t%-24s ; %02x:%04x  % x\n", line.Text, line.Bank, line.Addr, line.Bytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// where a jr, jp, call or rst goes, if it is known without running it
+func jumpTarget(code []byte, addr uint16) (uint16, bool) {
+	instruction, ok := gb.Decode(code)
+	if !ok || instruction.Size() > len(code) {
+		return 0, false
+	}
+
+	mnemonic := instruction.Mnemonic
+	switch {
+	case strings.HasPrefix(mnemonic, "JR"):
+		return relative(code, addr), true
+	case strings.HasPrefix(mnemonic, "JP") && strings.HasSuffix(mnemonic, "a16"),
+		strings.HasPrefix(mnemonic, "CALL"):
+		return word(code), true
+	case strings.HasPrefix(mnemonic, "RST"):
+		return rstTarget(mnemonic), true
+	}
+
+	return 0, false
+}
+
+// label names jump targets and memory operands
+func instruction(code []byte, addr uint16, label func(uint16) string) (string, int) {
+	instruction, ok := gb.Decode(code)
+	if !ok || instruction.Size() > len(code) {
+		return fmt.Sprintf("db $%02x", code[0]), 1
+	}
+
+	size := instruction.Size()
+	mnemonic := instruction.Mnemonic
+	text := strings.NewReplacer("(", "[", ")", "]").Replace(strings.ToLower(mnemonic))
+
+	switch {
+	case strings.HasPrefix(mnemonic, "RST"):
+		text = fmt.Sprintf("rst $%02x", rstTarget(mnemonic))
+	case strings.Contains(text, "[c]"):
+		text = "ldh" + strings.TrimPrefix(text, "ld")
+	case strings.Contains(text, "[a8]"):
+		text = "ldh" + strings.TrimPrefix(text, "ld")
+		text = strings.Replace(text, "a8", fmt.Sprintf("$ff%02x", code[1]), 1)
+	case strings.Contains(text, "sp+s8"):
+		text = strings.Replace(text, "+s8", fmt.Sprintf("%+d", int8(code[1])), 1)
+	case strings.HasPrefix(text, "jr"):
+		text = strings.Replace(text, "s8", label(relative(code, addr)), 1)
+	case strings.Contains(text, "s8"):
+		text = strings.Replace(text, "s8", strconv.Itoa(int(int8(code[1]))), 1)
+	case strings.Contains(text, "d8"):
+		text = strings.Replace(text, "d8", fmt.Sprintf("$%02x", code[1]), 1)
+	case strings.Contains(text, "a16"):
+		text = strings.Replace(text, "a16", label(word(code)), 1)
+	case strings.Contains(text, "d16"):
+		text = strings.Replace(text, "d16", fmt.Sprintf("$%04x", word(code)), 1)
+	}
+
+	return text, size
+}
+
+// the little endian operand of a 3 byte instruction
+func word(code []byte) uint16 {
+	return uint16(code[2])<<8 | uint16(code[1])
+}
+
+func relative(code []byte, addr uint16) uint16 {
+	return addr + 2 + uint16(int8(code[1]))
+}
+
+// "RST n" calls n * 8
+func rstTarget(mnemonic string) uint16 {
+	n, _ := strconv.Atoi(strings.TrimPrefix(mnemonic, "RST "))
+	return uint16(n * 8)
+}
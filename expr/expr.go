@@ -0,0 +1,89 @@
+package expr
+
+import "strings"
+
+// Var is a piece of emulator state an expression can name
+type Var int
+
+const (
+	A Var = iota
+	F
+	B
+	C
+	D
+	E
+	H
+	L
+	AF
+	BC
+	DE
+	HL
+	SP
+	PC
+
+	// flags, as 0 or 1
+	ZF
+	NF
+	HF
+	CF
+
+	// the scanline, and the ppu mode from stat
+	LY
+	Mode
+
+	// frames emulated since boot
+	Frame
+)
+
+var varNames = map[string]Var{
+	"a": A, "f": F, "b": B, "c": C, "d": D, "e": E, "h": H, "l": L,
+	"af": AF, "bc": BC, "de": DE, "hl": HL, "sp": SP, "pc": PC,
+	"zf": ZF, "nf": NF, "hf": HF, "cf": CF,
+	"ly": LY, "mode": Mode, "frame": Frame,
+}
+
+// Env is what expressions are evaluated against
+type Env interface {
+	Var(v Var) int
+	Read(addr uint16) byte
+}
+
+// Symbols resolves names that aren't variables, like rom labels, to addresses
+type Symbols func(name string) (uint16, bool)
+
+// Expr is a compiled expression, like "pc == $4a2f && [$d000] > 3"
+type Expr struct {
+	src  string
+	eval func(Env) int
+}
+
+// Compile parses src.  numbers are decimal unless written as $ff, 0xff
+// or 0b11, [addr] reads a byte and word[addr] a little endian word.
+// syms, which may be nil, names any other identifiers
+func Compile(src string, syms Symbols) (*Expr, error) {
+	p, err := newParser(src, syms)
+	if err != nil {
+		return nil, err
+	}
+
+	eval, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Expr{src: strings.TrimSpace(src), eval: eval}, nil
+}
+
+// Eval works out the value of e in env
+func (e *Expr) Eval(env Env) int {
+	return e.eval(env)
+}
+
+// True reports whether e is non-zero in env
+func (e *Expr) True(env Env) bool {
+	return e.eval(env) != 0
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
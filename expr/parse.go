@@ -0,0 +1,373 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  int
+}
+
+// two character operators, checked before single characters
+var ops2 = []string{"||", "&&", "==", "!=", "<=", ">=", "<<", ">>"}
+
+const ops1 = "|^&<>+-*/%!~()[]"
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+			continue
+		case c == '$' || isDigit(c):
+			j := i + 1
+			for j < len(src) && (isIdent(src[j])) {
+				j++
+			}
+
+			n, err := parseNumber(src[i:j])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[i:j], num: n})
+			i = j
+			continue
+		case isIdent(c):
+			j := i + 1
+			for j < len(src) && isIdent(src[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[i:j]})
+			i = j
+			continue
+		}
+
+		if i+1 < len(src) {
+			if op := src[i : i+2]; contains(ops2, op) {
+				tokens = append(tokens, token{kind: tokOp, text: op})
+				i += 2
+				continue
+			}
+		}
+
+		if strings.IndexByte(ops1, c) < 0 {
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+		tokens = append(tokens, token{kind: tokOp, text: string(c)})
+		i++
+	}
+
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+func parseNumber(s string) (int, error) {
+	lower := strings.ToLower(s)
+	base := 10
+
+	switch {
+	case strings.HasPrefix(lower, "$"):
+		lower, base = lower[1:], 16
+	case strings.HasPrefix(lower, "0x"):
+		lower, base = lower[2:], 16
+	case strings.HasPrefix(lower, "0b"):
+		lower, base = lower[2:], 2
+	}
+
+	n, err := strconv.ParseInt(lower, base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad number %q", s)
+	}
+
+	return int(n), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// rgbds labels can have dots in them, for local labels
+func isIdent(c byte) bool {
+	return c == '_' || c == '.' || isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'z')
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type eval func(Env) int
+
+type parser struct {
+	tokens []token
+	pos    int
+	syms   Symbols
+}
+
+func newParser(src string, syms Symbols) (*parser, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parser{tokens: tokens, syms: syms}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// consumes the operator op if it's next
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		return fmt.Errorf("expected %q, found %s", op, describe(p.peek()))
+	}
+	return nil
+}
+
+func describe(t token) string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func (p *parser) parse() (eval, error) {
+	if p.peek().kind == tokEOF {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	e, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s", describe(t))
+	}
+
+	return e, nil
+}
+
+// binary operators by precedence, loosest first
+var levels = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) binary(level int) (eval, error) {
+	if level == len(levels) {
+		return p.unary()
+	}
+
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != tokOp || !contains(levels[level], t.text) {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = apply(t.text, left, right)
+	}
+}
+
+func apply(op string, l, r eval) eval {
+	switch op {
+	case "||":
+		return func(env Env) int { return boolInt(l(env) != 0 || r(env) != 0) }
+	case "&&":
+		return func(env Env) int { return boolInt(l(env) != 0 && r(env) != 0) }
+	case "|":
+		return func(env Env) int { return l(env) | r(env) }
+	case "^":
+		return func(env Env) int { return l(env) ^ r(env) }
+	case "&":
+		return func(env Env) int { return l(env) & r(env) }
+	case "==":
+		return func(env Env) int { return boolInt(l(env) == r(env)) }
+	case "!=":
+		return func(env Env) int { return boolInt(l(env) != r(env)) }
+	case "<":
+		return func(env Env) int { return boolInt(l(env) < r(env)) }
+	case "<=":
+		return func(env Env) int { return boolInt(l(env) <= r(env)) }
+	case ">":
+		return func(env Env) int { return boolInt(l(env) > r(env)) }
+	case ">=":
+		return func(env Env) int { return boolInt(l(env) >= r(env)) }
+	case "<<":
+		return func(env Env) int { return l(env) << uint(r(env)&63) }
+	case ">>":
+		return func(env Env) int { return l(env) >> uint(r(env)&63) }
+	case "+":
+		return func(env Env) int { return l(env) + r(env) }
+	case "-":
+		return func(env Env) int { return l(env) - r(env) }
+	case "*":
+		return func(env Env) int { return l(env) * r(env) }
+	case "/":
+		// dividing by zero gives 0 rather than stopping the emulator
+		return func(env Env) int {
+			if d := r(env); d != 0 {
+				return l(env) / d
+			}
+			return 0
+		}
+	default:
+		return func(env Env) int {
+			if d := r(env); d != 0 {
+				return l(env) % d
+			}
+			return 0
+		}
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *parser) unary() (eval, error) {
+	for _, op := range []string{"!", "-", "~"} {
+		if !p.accept(op) {
+			continue
+		}
+
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "!":
+			return func(env Env) int { return boolInt(operand(env) == 0) }, nil
+		case "-":
+			return func(env Env) int { return -operand(env) }, nil
+		default:
+			return func(env Env) int { return ^operand(env) }, nil
+		}
+	}
+
+	return p.primary()
+}
+
+func (p *parser) primary() (eval, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokNumber:
+		n := t.num
+		return func(Env) int { return n }, nil
+	case tokIdent:
+		return p.ident(t.text)
+	case tokOp:
+		switch t.text {
+		case "(":
+			e, err := p.binary(0)
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		case "[":
+			return p.memory(false)
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %s", describe(t))
+}
+
+func (p *parser) ident(name string) (eval, error) {
+	lower := strings.ToLower(name)
+	if (lower == "word" || lower == "byte") && p.accept("[") {
+		return p.memory(lower == "word")
+	}
+
+	if v, ok := varNames[lower]; ok {
+		return func(env Env) int { return env.Var(v) }, nil
+	}
+
+	if p.syms != nil {
+		if addr, ok := p.syms(name); ok {
+			return func(Env) int { return int(addr) }, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown name %q", name)
+}
+
+// after the [ of [addr] or word[addr]
+func (p *parser) memory(word bool) (eval, error) {
+	addr, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+
+	if word {
+		return func(env Env) int {
+			a := uint16(addr(env))
+			return int(env.Read(a)) | int(env.Read(a+1))<<8
+		}, nil
+	}
+
+	return func(env Env) int { return int(env.Read(uint16(addr(env)))) }, nil
+}
@@ -0,0 +1,108 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+// a = $12, hl = $c000, the byte at $c000 is $34 and at $c001 is $56
+type testEnv struct{}
+
+func (testEnv) Var(v Var) int {
+	switch v {
+	case A:
+		return 0x12
+	case HL:
+		return 0xc000
+	case ZF:
+		return 1
+	}
+	return 0
+}
+
+func (testEnv) Read(addr uint16) byte {
+	switch addr {
+	case 0xc000:
+		return 0x34
+	case 0xc001:
+		return 0x56
+	}
+	return 0
+}
+
+func testSyms(name string) (uint16, bool) {
+	if name == "wHP" || name == "Main.loop" {
+		return 0xc000, true
+	}
+	return 0, false
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want int
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"2 * 3 % 4", 2},
+		{"1 << 2 + 1", 8},
+		{"1 + 1 == 2", 1},
+		{"1 | 2 == 2", 1},
+		{"6 & 3 ^ 1", 3},
+		{"1 || 0 && 0", 1},
+		{"3 < 4 == 1", 1},
+		{"-2 * 3", -6},
+		{"!0 + 1", 2},
+		{"~0 & $ff", 0xff},
+		{"--1", 1},
+		{"7 / 0", 0},
+		{"7 % 0", 0},
+		{"$ff + 0xFF + 0b11 + 10", 0xff + 0xff + 3 + 10},
+		{"a", 0x12},
+		{"A == $12 && zf", 1},
+		{"[hl]", 0x34},
+		{"[hl + 1]", 0x56},
+		{"word[$c000]", 0x5634},
+		{"byte[hl]", 0x34},
+		{"[wHP] < 10", 0},
+		{"Main.loop + 1", 0xc001},
+	}
+
+	for _, test := range tests {
+		e, err := Compile(test.src, testSyms)
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+			continue
+		}
+		if got := e.Eval(testEnv{}); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.src, got, test.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"", "empty expression"},
+		{"   ", "empty expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(1 + 2", `expected ")", found end of expression`},
+		{"[hl", `expected "]", found end of expression`},
+		{"1 2", `unexpected "2"`},
+		{"1 + )", `unexpected ")"`},
+		{"$fg", `bad number "$fg"`},
+		{"0b12", `bad number "0b12"`},
+		{"a @ 1", `unexpected '@'`},
+		{"wLives", `unknown name "wLives"`},
+	}
+
+	for _, test := range tests {
+		_, err := Compile(test.src, testSyms)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: got %v, want %s", test.src, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Template is a message with expressions in braces, like "hp {[wHP]} at {pc}",
+// for tracepoints
+type Template struct {
+	src   string
+	parts []part
+}
+
+// text, or an expression when e is set
+type part struct {
+	text string
+	e    *Expr
+}
+
+// CompileTemplate parses src, compiling each {expression} in it.  {{ and }}
+// are literal braces
+func CompileTemplate(src string, syms Symbols) (*Template, error) {
+	t := &Template{src: src}
+
+	var text strings.Builder
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+
+		switch {
+		case (c == '{' || c == '}') && i+1 < len(src) && src[i+1] == c:
+			text.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(src[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed { in %q", src)
+			}
+
+			e, err := Compile(src[i+1:i+end], syms)
+			if err != nil {
+				return nil, err
+			}
+
+			if text.Len() > 0 {
+				t.parts = append(t.parts, part{text: text.String()})
+				text.Reset()
+			}
+			t.parts = append(t.parts, part{e: e})
+			i += end
+		case c == '}':
+			return nil, fmt.Errorf("unopened } in %q", src)
+		default:
+			text.WriteByte(c)
+		}
+	}
+
+	if text.Len() > 0 {
+		t.parts = append(t.parts, part{text: text.String()})
+	}
+
+	return t, nil
+}
+
+// Render fills in the expressions' values in env, in hex like the debugger
+func (t *Template) Render(env Env) string {
+	var b strings.Builder
+
+	for _, p := range t.parts {
+		if p.e == nil {
+			b.WriteString(p.text)
+			continue
+		}
+
+		b.WriteString(Format(p.e.Eval(env)))
+	}
+
+	return b.String()
+}
+
+func (t *Template) String() string {
+	return t.src
+}
+
+// Format writes a value as $xx, or $xxxx if it doesn't fit in a byte
+func Format(n int) string {
+	switch {
+	case n < 0:
+		return fmt.Sprint(n)
+	case n <= 0xff:
+		return fmt.Sprintf("$%02x", n)
+	}
+
+	return fmt.Sprintf("$%04x", n)
+}
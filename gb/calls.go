@@ -0,0 +1,150 @@
+package gb
+
+// how an opcode moves the call stack
+type callKind byte
+
+const (
+	notCall callKind = iota
+	isCall
+	isRet
+)
+
+var callKinds = func() (kinds [256]callKind) {
+	for _, op := range []byte{0xc4, 0xcc, 0xcd, 0xd4, 0xdc, 0xc7, 0xcf, 0xd7, 0xdf, 0xe7, 0xef, 0xf7, 0xff} {
+		kinds[op] = isCall
+	}
+	for _, op := range []byte{0xc0, 0xc8, 0xc9, 0xd0, 0xd8, 0xd9} {
+		kinds[op] = isRet
+	}
+	return kinds
+}()
+
+// deepest the shadow stack gets before the oldest frames are dropped,
+// in case a rom never returns from its calls
+const maxCallDepth = 1024
+
+// CallFrame is a call that hasn't returned yet
+type CallFrame struct {
+	// the call or rst instruction
+	Call uint16
+
+	// where it went, and where it returns to
+	Target uint16
+	Return uint16
+
+	// sp after pushing the return address
+	SP uint16
+}
+
+// BadReturn is a ret that took its return address from a call's slot
+// on the stack but didn't go back after that call, which usually means
+// something overwrote the stack
+type BadReturn struct {
+	// the ret or reti, and where it went
+	PC     uint16
+	Target uint16
+
+	// the call it should have returned from
+	Expected CallFrame
+}
+
+// a shadow of the call stack, built by watching calls and returns
+// rather than trusting whatever is in memory at sp
+type callStack struct {
+	frames []CallFrame
+
+	// changes whenever frames do
+	version uint64
+
+	// the latest bad return, and whether the last instruction made it
+	bad    *BadReturn
+	badNow bool
+}
+
+// EnableCallStack starts or stops tracking calls for CallStack
+func (gb *Gb) EnableCallStack(enable bool) {
+	gb.do(func() {
+		if !enable {
+			gb.cpu.calls = nil
+		} else if gb.cpu.calls == nil {
+			gb.cpu.calls = new(callStack)
+		}
+	})
+}
+
+// CallStack returns the calls that haven't returned, innermost first
+func (gb *Gb) CallStack() (frames []CallFrame) {
+	gb.do(func() {
+		if gb.cpu.calls == nil {
+			return
+		}
+
+		stack := gb.cpu.calls.frames
+		for i := len(stack) - 1; i >= 0; i-- {
+			frames = append(frames, stack[i])
+		}
+	})
+	return frames
+}
+
+// LastBadReturn returns the latest return that looked like stack
+// corruption, while tracking calls
+func (gb *Gb) LastBadReturn() (ret BadReturn, ok bool) {
+	gb.do(func() {
+		if calls := gb.cpu.calls; calls != nil && calls.bad != nil {
+			ret, ok = *calls.bad, true
+		}
+	})
+	return ret, ok
+}
+
+// called after every instruction while tracking calls, with pc and sp
+// from before it.  goboy doesn't dispatch interrupts yet; when it does,
+// the dispatch should push their frames
+func (c *callStack) track(cpu *cpu, pc, sp uint16) {
+	opcode := cpu.fetchByte(pc)
+	size := uint16(InstructionTable8[opcode].size)
+	c.badNow = false
+
+	switch callKinds[opcode] {
+	case isCall:
+		if cpu.pc != pc+size {
+			c.push(CallFrame{Call: pc, Target: cpu.pc, Return: pc + size, SP: cpu.sp})
+		}
+	case isRet:
+		// a ret of something pushed since the call, like a jump
+		// table's address, isn't returning from it
+		if n := len(c.frames); n > 0 && cpu.pc != pc+size {
+			top := c.frames[n-1]
+			if sp == top.SP && cpu.pc != top.Return {
+				c.bad = &BadReturn{PC: pc, Target: cpu.pc, Expected: top}
+				c.badNow = true
+			}
+		}
+	}
+
+	// frames whose return address is above sp have been returned from,
+	// or dropped by a rom moving sp itself
+	for n := len(c.frames); n > 0 && c.frames[n-1].SP < cpu.sp; n-- {
+		c.frames = c.frames[:n-1]
+		c.version++
+	}
+}
+
+func (c *callStack) push(frame CallFrame) {
+	if len(c.frames) == maxCallDepth {
+		c.frames = c.frames[1:]
+	}
+	c.frames = append(c.frames, frame)
+	c.version++
+}
+
+// safe to call on a nil stack
+func (c *callStack) clear() {
+	if c != nil {
+		c.frames = nil
+		c.version++
+		c.bad = nil
+		c.badNow = false
+	}
+}
@@ -0,0 +1,112 @@
+package gb
+
+import "github.com/justinawrey/goboy/symbols"
+
+// CodeDataLog has a byte of flags for each byte of a rom file, saying how
+// emulation used it.  the flags are goboy's own, so other emulators' and
+// disassemblers' .cdl files won't read the same
+type CodeDataLog []byte
+
+const (
+	// executed as an instruction's first byte
+	CDLCode byte = 1 << iota
+
+	// executed as the rest of an instruction
+	CDLOperand
+
+	// read as data
+	CDLData
+
+	// read as data and copied into vram soon after
+	CDLGraphics
+)
+
+// a rom byte copied into vram within this many instructions of reading it
+// counts as graphics, which catches the usual copy loops
+const graphicsWindow = 3
+
+const romBankSize = 0x4000
+
+// marks the rom bytes emulation uses in a CodeDataLog
+type codeDataLogger struct {
+	log     CodeDataLog
+	romBank func() int
+
+	// the instruction executing, whose operands aren't data
+	pc   uint16
+	size uint16
+
+	// the last rom byte read as data, for spotting it being copied to vram
+	read    int
+	value   byte
+	readAt  uint64
+	hasRead bool
+}
+
+// StartCodeDataLog marks the rom bytes executed and read into cdl, which
+// may already have marks from earlier runs.  it's left alone until
+// StopCodeDataLog, and bytes past its end aren't marked
+func (gb *Gb) StartCodeDataLog(cdl CodeDataLog) {
+	gb.do(func() {
+		gb.cpu.cdl = &codeDataLogger{log: cdl, romBank: gb.RomBank}
+		gb.updateDebug()
+	})
+}
+
+// StopCodeDataLog stops marking the CodeDataLog StartCodeDataLog was given
+func (gb *Gb) StopCodeDataLog() {
+	gb.do(func() {
+		gb.cpu.cdl = nil
+		gb.updateDebug()
+	})
+}
+
+// the offset in the rom file of addr, or -1 if it isn't rom
+func (c *codeDataLogger) offset(addr uint16) int {
+	if addr >= romSize {
+		return -1
+	}
+
+	return symbols.BankAt(addr, c.romBank())*romBankSize + int(addr%romBankSize)
+}
+
+func (c *codeDataLogger) mark(addr uint16, flag byte) int {
+	i := c.offset(addr)
+	if i >= 0 && i < len(c.log) {
+		c.log[i] |= flag
+	}
+	return i
+}
+
+// called before the instruction of size at pc executes
+func (c *codeDataLogger) execute(pc uint16, size int) {
+	c.pc, c.size = pc, uint16(size)
+
+	c.mark(pc, CDLCode)
+	for i := uint16(1); i < c.size; i++ {
+		c.mark(pc+i, CDLOperand)
+	}
+}
+
+// called for every read and write while logging
+func (c *codeDataLogger) access(cpu *cpu, addr uint16, write bool, value byte) {
+	if !write {
+		// operands are read through the bus too
+		if addr-c.pc < c.size {
+			return
+		}
+
+		if i := c.mark(addr, CDLData); i >= 0 {
+			c.read, c.value, c.readAt, c.hasRead = i, value, cpu.instructions, true
+		}
+		return
+	}
+
+	if addr < 0x8000 || addr >= 0xa000 || !c.hasRead {
+		return
+	}
+
+	if value == c.value && cpu.instructions-c.readAt <= graphicsWindow && c.read < len(c.log) {
+		c.log[c.read] |= CDLGraphics
+	}
+}
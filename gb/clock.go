@@ -0,0 +1,96 @@
+package gb
+
+import (
+	"math"
+	"time"
+)
+
+const minSpeed = 0.25
+
+// clock decides when the main loop emulates and renders frames
+type clock struct {
+	paused bool
+
+	// frames still to emulate while paused
+	advance int
+
+	// multiple of real time, 0 for unlimited
+	speed float64
+
+	// when faster than real time, only render as often as the
+	// real hardware would
+	skip       bool
+	lastRender time.Time
+
+	pacer
+}
+
+func newClock() clock {
+	return clock{speed: 1, pacer: newPacer()}
+}
+
+// TogglePause pauses a running Gb or resumes a paused one
+func (gb *Gb) TogglePause() {
+	gb.do(func() {
+		if gb.clock.paused {
+			gb.resume()
+		} else {
+			gb.clock.paused = true
+		}
+	})
+}
+
+// AdvanceFrame emulates and renders one frame while paused
+func (gb *Gb) AdvanceFrame() {
+	gb.do(func() {
+		if gb.clock.paused {
+			gb.clock.advance++
+		}
+	})
+}
+
+// SetSpeed runs emulation at multiplier times real time, no slower
+// than 0.25x.  0 or +Inf runs as fast as possible
+func (gb *Gb) SetSpeed(multiplier float64) {
+	if multiplier == 0 || math.IsInf(multiplier, 1) {
+		multiplier = 0
+	} else {
+		multiplier = math.Max(multiplier, minSpeed)
+	}
+
+	gb.do(func() {
+		gb.clock.speed = multiplier
+		gb.clock.resync()
+	})
+}
+
+// SetFrameSkip controls whether frames are dropped instead of
+// rendered while running faster than real time
+func (gb *Gb) SetFrameSkip(skip bool) {
+	gb.do(func() { gb.clock.skip = skip })
+}
+
+// wall time each frame takes at the current speed, 0 for unlimited
+func (c *clock) perFrame() time.Duration {
+	if c.speed == 0 || c.advance > 0 {
+		return 0
+	}
+
+	return time.Duration(float64(timePerFrame) / c.speed)
+}
+
+// whether the frame just emulated should be rendered, when fast-forwarding
+func (c *clock) shouldRender() bool {
+	fast := c.speed == 0 || c.speed > 1
+	if !c.skip || !fast || c.advance > 0 {
+		return true
+	}
+
+	now := time.Now()
+	if now.Sub(c.lastRender) < timePerFrame {
+		return false
+	}
+
+	c.lastRender = now
+	return true
+}
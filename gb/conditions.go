@@ -0,0 +1,159 @@
+package gb
+
+import (
+	"sort"
+
+	"github.com/justinawrey/goboy/expr"
+)
+
+// a breakpoint reached, which breaks if its condition holds
+type hit struct {
+	id int
+
+	// the read or write that reached a watchpoint
+	access *Access
+
+	// the return that reached a BreakBadReturn breakpoint
+	ret *BadReturn
+}
+
+// works out which of the breakpoints reached count as hits, logs the
+// tracepoints and stops at the first of the rest
+func (gb *Gb) breakOnHits() bool {
+	d := gb.debug
+	if len(d.hits) == 0 {
+		return false
+	}
+
+	hits := d.hits
+	d.hits = d.hits[:0]
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].id < hits[j].id })
+
+	env := exprEnv{gb}
+	var stop *Stop
+
+	for i, h := range hits {
+		// a watchpoint reached twice in one instruction only counts once
+		if i > 0 && hits[i-1].id == h.id {
+			continue
+		}
+
+		bp, ok := d.breakpoints[h.id]
+		if !ok || (bp.Expr != nil && !bp.Expr.True(env)) {
+			continue
+		}
+
+		bp.Hits++
+		d.breakpoints[h.id] = bp
+		if bp.Hits <= bp.Ignore {
+			continue
+		}
+
+		if bp.Log != nil {
+			msg := ""
+			if bp.Message != nil {
+				msg = bp.Message.Render(env)
+			}
+			bp.Log(h.id, msg)
+			continue
+		}
+
+		if stop == nil {
+			stop = &Stop{Reason: StopBreakpoint, Breakpoint: h.id}
+			if h.access != nil && h.access.Kind != AccessExecute {
+				stop.Access = h.access
+			}
+			stop.BadReturn = h.ret
+		}
+	}
+
+	if stop == nil {
+		return false
+	}
+
+	gb.stop(*stop)
+	return true
+}
+
+// UpdateBreakpoint replaces the breakpoint with id, keeping its hit count,
+// and reports whether there was one
+func (gb *Gb) UpdateBreakpoint(id int, bp Breakpoint) (ok bool) {
+	gb.do(func() {
+		var old Breakpoint
+		if old, ok = gb.debug.breakpoints[id]; !ok {
+			return
+		}
+
+		bp.Hits = old.Hits
+		gb.debug.breakpoints[id] = bp
+		gb.updateDebug()
+	})
+	return ok
+}
+
+// Eval works out e's value now
+func (gb *Gb) Eval(e *expr.Expr) (n int) {
+	gb.do(func() { n = e.Eval(exprEnv{gb}) })
+	return n
+}
+
+// lets expressions see the emulator's state.  memory reads don't count
+// as accesses, so they can't set off watchpoints
+type exprEnv struct {
+	gb *Gb
+}
+
+func (env exprEnv) Var(v expr.Var) int {
+	cpu := env.gb.cpu
+
+	switch v {
+	case expr.A:
+		return int(cpu.a)
+	case expr.F:
+		return int(cpu.f())
+	case expr.B:
+		return int(cpu.b)
+	case expr.C:
+		return int(cpu.c)
+	case expr.D:
+		return int(cpu.d)
+	case expr.E:
+		return int(cpu.e)
+	case expr.H:
+		return int(cpu.h)
+	case expr.L:
+		return int(cpu.l)
+	case expr.AF:
+		return int(makeWord(cpu.a, cpu.f()))
+	case expr.BC:
+		return int(makeWord(cpu.b, cpu.c))
+	case expr.DE:
+		return int(makeWord(cpu.d, cpu.e))
+	case expr.HL:
+		return int(makeWord(cpu.h, cpu.l))
+	case expr.SP:
+		return int(cpu.sp)
+	case expr.PC:
+		return int(cpu.pc)
+	case expr.ZF:
+		return int(cpu.f()>>7) & 1
+	case expr.NF:
+		return int(cpu.f()>>6) & 1
+	case expr.HF:
+		return int(cpu.f()>>5) & 1
+	case expr.CF:
+		return int(cpu.f()>>4) & 1
+	case expr.LY:
+		return int(env.Read(0xff44))
+	case expr.Mode:
+		return int(env.Read(0xff41) & 0b11)
+	case expr.Frame:
+		return int(env.gb.numFrames)
+	}
+
+	return 0
+}
+
+func (env exprEnv) Read(addr uint16) byte {
+	return env.gb.memory.fetchByte(addr)
+}
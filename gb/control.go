@@ -0,0 +1,203 @@
+package gb
+
+import "sync"
+
+// commands funnel every exported method that touches emulator state
+// through the goroutine running Run, so debuggers, servers and
+// frontends can share one Gb without racing each other
+type commands struct {
+	mu      sync.Mutex
+	running bool
+	pending []func()
+
+	// held while a command runs right away, so they run one at a time.
+	// running only changes while it's held, so Run starts and stops
+	// between them
+	direct sync.Mutex
+
+	// signalled when something is added to pending
+	wake chan struct{}
+}
+
+func newCommands() *commands {
+	return &commands{wake: make(chan struct{}, 1)}
+}
+
+// do runs f on the emulation goroutine between frames and waits
+// for it.  while Run isn't running, f runs right away instead, one
+// command at a time.  commands never re-enter do: their bodies use the
+// unexported methods, never the exported ones
+func (gb *Gb) do(f func()) {
+	q := gb.commands
+	for {
+		q.mu.Lock()
+		if q.running {
+			q.enqueue(f)
+			return
+		}
+		q.mu.Unlock()
+
+		if q.runDirect(f) {
+			return
+		}
+	}
+}
+
+// queues f for Run and waits for it.  called with mu held
+func (q *commands) enqueue(f func()) {
+	// if f panics, its caller still gets to carry on
+	done := make(chan struct{})
+	q.pending = append(q.pending, func() {
+		defer close(done)
+		f()
+	})
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	<-done
+}
+
+// runs f once it's its turn, unless Run started meanwhile
+func (q *commands) runDirect(f func()) bool {
+	q.direct.Lock()
+	defer q.direct.Unlock()
+
+	q.mu.Lock()
+	running := q.running
+	q.mu.Unlock()
+	if running {
+		return false
+	}
+
+	f()
+	return true
+}
+
+// runs everything queued so far, on the emulation goroutine
+func (gb *Gb) runCommands() {
+	q := gb.commands
+	q.mu.Lock()
+	pending := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+
+	// if one panics, the ones after it go back on the queue for stop
+	i := 0
+	defer func() {
+		if i < len(pending)-1 {
+			q.mu.Lock()
+			q.pending = append(append([]func(){}, pending[i+1:]...), q.pending...)
+			q.mu.Unlock()
+		}
+	}()
+
+	for ; i < len(pending); i++ {
+		pending[i]()
+	}
+}
+
+// runs boot as a command, then from now on commands wait for the
+// emulation goroutine
+func (q *commands) start(boot func() error) error {
+	q.direct.Lock()
+	defer q.direct.Unlock()
+
+	if err := boot(); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.running = true
+	q.mu.Unlock()
+	return nil
+}
+
+// from now on, commands run right away.
+// anything still queued runs before this returns
+func (q *commands) stop() {
+	q.direct.Lock()
+	defer q.direct.Unlock()
+
+	q.mu.Lock()
+	q.running = false
+	pending := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+
+	for _, f := range pending {
+		f()
+	}
+}
+
+// Pause stops emulation after the current frame.
+// like the rest of the control api, it is safe to call
+// from any goroutine, including from within Render
+func (gb *Gb) Pause() {
+	gb.do(func() { gb.clock.paused = true })
+}
+
+// Resume continues emulation at the current speed
+func (gb *Gb) Resume() {
+	gb.do(gb.resume)
+}
+
+func (gb *Gb) resume() {
+	gb.clock.paused = false
+	gb.clock.resync()
+	gb.debug.skipPC = true
+	gb.debug.skipUnimplemented = true
+	gb.debug.dropStop()
+}
+
+// Paused reports whether emulation is paused
+func (gb *Gb) Paused() (paused bool) {
+	gb.do(func() { paused = gb.clock.paused })
+	return paused
+}
+
+// Step pauses emulation and executes a single instruction,
+// ignoring breakpoints
+func (gb *Gb) Step() {
+	gb.do(func() {
+		gb.clock.paused = true
+		if gb.debug.active {
+			gb.execute()
+		}
+		gb.step()
+		gb.debug.hits = gb.debug.hits[:0]
+	})
+}
+
+// Reset restarts the cartridge.  a soft reset keeps the contents
+// of ram, a hard reset re-initializes it as if the power was cycled
+func (gb *Gb) Reset(hard bool) {
+	gb.do(func() { gb.reset(hard) })
+}
+
+// ReadMemory returns length bytes of the address space starting at addr,
+// wrapping around at 0xffff
+func (gb *Gb) ReadMemory(addr uint16, length int) []byte {
+	data := make([]byte, length)
+
+	gb.do(func() {
+		for i := range data {
+			data[i] = gb.memory.fetchByte(addr + uint16(i))
+		}
+	})
+
+	return data
+}
+
+// WriteMemory writes data into the address space starting at addr,
+// wrapping around at 0xffff
+func (gb *Gb) WriteMemory(addr uint16, data []byte) {
+	gb.do(func() {
+		for i, b := range data {
+			gb.memory.storeByte(addr+uint16(i), b)
+		}
+	})
+}
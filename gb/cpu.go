@@ -4,6 +4,9 @@ const (
 	cpuHz             = 4194304
 	cyclesPerFrame    = 70224
 	cyclesPerScanline = 456
+
+	// what a locked up cpu spends on each attempt at an instruction
+	lockedCycles = 4
 )
 
 type cpu struct {
@@ -13,6 +16,33 @@ type cpu struct {
 	sp                  uint16
 	pc                  uint16
 	flags
+
+	// cycles into the current frame and scanline
+	frameCycles int
+	scanCycles  int
+
+	// instructions executed since boot, which places a moment in the history
+	instructions uint64
+
+	// nil unless StartTrace was called
+	trace *tracer
+
+	// nil unless EnableCallStack was called
+	calls *callStack
+
+	// nil unless StartProfile was called
+	profile *profiler
+
+	// nil unless StartCodeDataLog was called
+	cdl *codeDataLogger
+
+	// nil unless EnableCrashReports was called
+	recent *recentInstructions
+
+	// set by an illegal opcode, which hangs the cpu until a reset.
+	// lockup says which, until the Gb reports it
+	locked bool
+	lockup *IllegalOpcodeError
 }
 
 type flags struct {
@@ -43,6 +73,18 @@ func (cpu *cpu) setHl(word uint16) {
 	cpu.h, cpu.l = splitWord(word)
 }
 
+// the flags as the f register: z n h c in bits 7 - 4
+func (f *flags) f() byte {
+	return toUint8(f.z)<<7 | toUint8(f.n)<<6 | toUint8(f.h)<<5 | toUint8(f.c)<<4
+}
+
+func (f *flags) setF(b byte) {
+	f.z = b&0x80 != 0
+	f.n = b&0x40 != 0
+	f.h = b&0x20 != 0
+	f.c = b&0x10 != 0
+}
+
 // TODO: this half carry logic could be streamlined
 func (f *flags) setH3Add(b1 byte, b2 byte) {
 	f.h = (((b1 & 0x0f) + (b2 & 0x0f)) & 0x10) == 0x10
@@ -89,11 +131,11 @@ func (f *flags) setZ(test byte) {
 }
 
 func (cpu *cpu) decode() Instruction {
-	b1 := cpu.readByte(cpu.pc)
+	b1 := cpu.fetchByte(cpu.pc)
 
 	// 16-bit instructions
 	if b1 == 0x10 || b1 == 0xcb {
-		b2 := cpu.readByte(cpu.pc + 1)
+		b2 := cpu.fetchByte(cpu.pc + 1)
 		return InstructionTable16[makeWord(b1, b2)]
 	}
 
@@ -102,41 +144,99 @@ func (cpu *cpu) decode() Instruction {
 }
 
 // invoked at 60Hz
-// TODO: this could be factored out in a nicer way probably?
 func (cpu *cpu) tick() {
-	elapsedCycles := 0
-	scanCycles := 0
-
-	for elapsedCycles < cyclesPerFrame {
-		cycles := cpu.executeInstruction()
-
-		elapsedCycles += cycles
-		scanCycles += cycles
-
-		// while the lcd is disabled,
-		// 1. scanline is set at 0
-		// 2. mode is set to v-blank (mode 1)
-		if !cpu.ppu.lcdEnable() {
-			cpu.ppu.ly.set(0)
-			cpu.ppu.lcds.set((cpu.ppu.lcds.get() & 0b11111100) | 1)
-			scanCycles = 0
-			continue
-		}
+	for !cpu.step() {
+	}
+}
+
+// executes a single instruction and catches the ppu up.
+// returns whether that finished the frame
+// TODO: this could be factored out in a nicer way probably?
+func (cpu *cpu) step() (frameDone bool) {
+	// a locked up cpu does nothing, but the ppu carries on
+	if cpu.locked {
+		return cpu.advance(lockedCycles)
+	}
 
-		cpu.ppu.updateLcdStatus(scanCycles)
+	pc, sp := cpu.pc, cpu.sp
+	if cpu.recent != nil {
+		cpu.recent.add(cpu)
+	}
+
+	cycles := cpu.executeInstruction()
+	if cpu.locked {
+		return cpu.advance(cycles)
+	}
+	cpu.instructions++
+
+	// counted before the call stack moves, so calls and returns
+	// count in the routine they're in
+	if cpu.profile != nil {
+		cpu.profile.add(cpu, pc, cycles)
+	}
+	if cpu.calls != nil {
+		cpu.calls.track(cpu, pc, sp)
+	}
+
+	return cpu.advance(cycles)
+}
+
+// catches the ppu up with cycles more of the cpu.
+// returns whether that finished the frame
+func (cpu *cpu) advance(cycles int) (frameDone bool) {
+	cpu.frameCycles += cycles
+	cpu.scanCycles += cycles
+
+	// while the lcd is disabled,
+	// 1. scanline is set at 0
+	// 2. mode is set to v-blank (mode 1)
+	if !cpu.ppu.lcdEnable() {
+		cpu.ppu.ly.set(0)
+		cpu.ppu.lcds.set((cpu.ppu.lcds.get() & 0b11111100) | 1)
+		cpu.scanCycles = 0
+	} else {
+		cpu.ppu.updateLcdStatus(cpu.scanCycles)
 
-		if scanCycles >= cyclesPerScanline {
+		if cpu.scanCycles >= cyclesPerScanline {
 			cpu.ppu.drawScanline()
 			cpu.ppu.incrementScanline()
-			scanCycles = 0
+			cpu.scanCycles = 0
 		}
 	}
+
+	if cpu.frameCycles < cyclesPerFrame {
+		return false
+	}
+
+	cpu.frameCycles = 0
+	cpu.scanCycles = 0
+	return true
 }
 
 // does a decode, execute, move pc cycle
 // returns number of cycles elapsed
 func (cpu *cpu) executeInstruction() (cycles int) {
+	if cpu.trace != nil {
+		cpu.trace.log(cpu)
+	}
+
 	instruction := cpu.decode()
+	if instruction.execute == nil {
+		op := cpu.fetchByte(cpu.pc)
+		if illegalOpcodes[op] {
+			cpu.lockUp(op)
+			return lockedCycles
+		}
+
+		// a stop with a second byte other than 00, which the
+		// unimplemented policy has seen to.  it's skipped
+		cpu.pc += 2
+		return InstructionTable16[0x1000].noJumpCycles
+	}
+
+	if cpu.cdl != nil {
+		cpu.cdl.execute(cpu.pc, instruction.size)
+	}
 
 	currPc := cpu.pc
 	instruction.execute(cpu)
@@ -0,0 +1,205 @@
+package gb
+
+import (
+	"bufio"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/justinawrey/goboy/symbols"
+)
+
+// CrashError is returned by Run when emulation crashed while crash
+// reports were enabled
+type CrashError struct {
+	// what went wrong, like the value a panic was raised with
+	Reason string
+
+	// the directory the report was written to, empty if writing it failed
+	Report string
+}
+
+func (e *CrashError) Error() string {
+	if e.Report == "" {
+		return fmt.Sprintf("gb: crashed: %s", e.Reason)
+	}
+	return fmt.Sprintf("gb: crashed: %s, report in %s", e.Reason, e.Report)
+}
+
+// RecentInstruction is one of the instructions that led up to a crash
+type RecentInstruction struct {
+	// registers before it executed
+	Registers
+
+	Bank  int
+	Bytes [3]byte
+}
+
+// ring of the instructions executed last
+type recentInstructions struct {
+	romBank func() int
+
+	entries []RecentInstruction
+	next    int
+	full    bool
+}
+
+// EnableCrashReports keeps the last history instructions executed, and
+// if emulation panics or an illegal opcode locks the cpu up, makes Run
+// write a report to a new directory in dir and return a CrashError.  the
+// report has the instructions, the registers, the io registers and hram,
+// a save state and a screenshot.  an empty dir disables it
+func (gb *Gb) EnableCrashReports(dir string, history int) {
+	gb.do(func() {
+		gb.crashDir = dir
+		gb.cpu.recent = nil
+		if dir != "" && history > 0 {
+			gb.cpu.recent = &recentInstructions{romBank: gb.RomBank, entries: make([]RecentInstruction, history)}
+		}
+	})
+}
+
+// called before every instruction while keeping them
+func (r *recentInstructions) add(cpu *cpu) {
+	e := &r.entries[r.next]
+	e.Registers = Registers{
+		A: cpu.a, F: cpu.f(), B: cpu.b, C: cpu.c,
+		D: cpu.d, E: cpu.e, H: cpu.h, L: cpu.l,
+		SP: cpu.sp, PC: cpu.pc,
+	}
+	e.Bank = symbols.BankAt(cpu.pc, r.romBank())
+	e.Bytes = [3]byte{cpu.fetchByte(cpu.pc), cpu.fetchByte(cpu.pc + 1), cpu.fetchByte(cpu.pc + 2)}
+
+	r.next++
+	if r.next == len(r.entries) {
+		r.next, r.full = 0, true
+	}
+}
+
+// oldest first
+func (r *recentInstructions) list() []RecentInstruction {
+	if !r.full {
+		return append([]RecentInstruction(nil), r.entries[:r.next]...)
+	}
+	return append(append([]RecentInstruction(nil), r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// deferred by Run, to turn a panic into a crash report
+func (gb *Gb) recoverCrash(err *error) {
+	if gb.crashDir == "" {
+		return
+	}
+
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	*err = gb.crash(fmt.Sprint(r), debug.Stack())
+}
+
+// writes a crash report and returns the CrashError for it.  stack is the
+// go stack, if it's a go problem
+func (gb *Gb) crash(reason string, stack []byte) error {
+	crash := &CrashError{Reason: reason}
+
+	dir, err := gb.writeCrashReport(reason, stack)
+	if err != nil {
+		crash.Reason = fmt.Sprintf("%s (writing the report failed: %v)", reason, err)
+		return crash
+	}
+
+	crash.Report = dir
+	return crash
+}
+
+// the directory is named for the time, with a random suffix so crashes in
+// the same second don't write over each other
+func (gb *Gb) writeCrashReport(reason string, stack []byte) (string, error) {
+	if err := os.MkdirAll(gb.crashDir, 0755); err != nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp(gb.crashDir, time.Now().Format("goboy-crash-20060102-150405-"))
+	if err != nil {
+		return "", err
+	}
+
+	err = WriteFile(filepath.Join(dir, "report.txt"), func(w io.Writer) error {
+		return gb.writeCrashText(w, reason, stack)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = WriteFile(filepath.Join(dir, "crash.state"), gb.saveState)
+	if err != nil {
+		return "", err
+	}
+
+	frame := newFrame(FormatShades)
+	frame.fill(gb.ppu.pixels)
+	err = WriteFile(filepath.Join(dir, "screen.png"), func(w io.Writer) error {
+		return png.Encode(w, frame.Image())
+	})
+	return dir, err
+}
+
+// WriteFile creates the file at path and has write fill it, returning the
+// first error from writing or closing it
+func WriteFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (gb *Gb) writeCrashText(w io.Writer, reason string, stack []byte) error {
+	bw := bufio.NewWriter(w)
+	cpu := gb.cpu
+
+	fmt.Fprintf(bw, "goboy crashed: %s\n", reason)
+	fmt.Fprintf(bw, "frame %d, %d instructions since boot\n\n", gb.numFrames, cpu.instructions)
+
+	fmt.Fprintf(bw, "a  %02x   f  %02x\n", cpu.a, cpu.f())
+	fmt.Fprintf(bw, "bc %02x%02x  de %02x%02x  hl %02x%02x\n", cpu.b, cpu.c, cpu.d, cpu.e, cpu.h, cpu.l)
+	fmt.Fprintf(bw, "sp %04x  pc %04x  rom bank %d\n\n", cpu.sp, cpu.pc, gb.RomBank())
+
+	if cpu.recent != nil {
+		recent := cpu.recent.list()
+		fmt.Fprintf(bw, "last %d instructions, oldest first\n", len(recent))
+		for _, r := range recent {
+			in, ok := Decode(r.Bytes[:])
+			size, mnemonic := in.size, in.Mnemonic
+			if !ok {
+				size, mnemonic = 1, "(illegal)"
+			}
+
+			fmt.Fprintf(bw, "%02x:%04x  %-8s  %-14s  a %02x f %02x bc %02x%02x de %02x%02x hl %02x%02x sp %04x\n",
+				r.Bank, r.PC, fmt.Sprintf("% x", r.Bytes[:size]), mnemonic,
+				r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, r.SP)
+		}
+		fmt.Fprintln(bw)
+	}
+
+	fmt.Fprintln(bw, "io registers and hram")
+	for addr := 0xff00; addr <= 0xffff; addr += 16 {
+		fmt.Fprintf(bw, "%04x  % x\n", addr, gb.memory.data[addr:addr+16])
+	}
+
+	if stack != nil {
+		fmt.Fprintf(bw, "\n%s", stack)
+	}
+
+	return bw.Flush()
+}
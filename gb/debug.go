@@ -0,0 +1,398 @@
+package gb
+
+import (
+	"sort"
+
+	"github.com/justinawrey/goboy/expr"
+)
+
+type BreakKind int
+
+const (
+	// break before executing the instruction at Addr
+	BreakPC BreakKind = iota
+
+	// break before executing any Opcode
+	BreakOpcode
+
+	// break after an instruction reads / writes / reads or writes Addr
+	BreakRead
+	BreakWrite
+	BreakAccess
+
+	// break before any instruction where Expr is true
+	BreakExpr
+
+	// break after a ret that looks like stack corruption, see BadReturn
+	BreakBadReturn
+)
+
+type Breakpoint struct {
+	Kind BreakKind
+	Addr uint16
+
+	// the last address of a range starting at Addr, 0 for just Addr
+	End uint16
+
+	// an 8-bit opcode, or 0xcbxx / 0x10xx for the 16-bit ones
+	Opcode uint16
+
+	// only break when the byte read or written, or the opcode
+	// executed, passes Cond
+	Cond  ValueCond
+	Value byte
+
+	// only break when Expr is true, if it's set
+	Expr *expr.Expr
+
+	// hits to let pass before breaking
+	Ignore int
+
+	// a tracepoint calls Log with Message filled in instead of stopping.
+	// Log runs on the goroutine running the Gb
+	Log     func(id int, msg string)
+	Message *expr.Template
+
+	// times Expr was true when the breakpoint was reached, including
+	// ignored hits.  kept up to date by the Gb
+	Hits int
+}
+
+// ValueCond narrows down which accesses a breakpoint breaks on
+type ValueCond int
+
+const (
+	AnyValue ValueCond = iota
+	ValueEquals
+	ValueNotEquals
+
+	// only writes that change the byte
+	ValueChanges
+)
+
+// the accesses a breakpoint watches, opcode breakpoints are checked separately
+func (bp Breakpoint) accesses() AccessKind {
+	switch bp.Kind {
+	case BreakPC:
+		return AccessExecute
+	case BreakRead:
+		return AccessRead
+	case BreakWrite:
+		return AccessWrite
+	case BreakAccess:
+		return AccessRead | AccessWrite
+	}
+
+	return 0
+}
+
+func (bp Breakpoint) last() uint16 {
+	if bp.End < bp.Addr {
+		return bp.Addr
+	}
+
+	return bp.End
+}
+
+type StopReason int
+
+const (
+	StopBreakpoint StopReason = iota
+	StopFrame
+
+	// ReverseContinue went back as far as the history goes
+	StopHistoryStart
+
+	// about to run an unimplemented instruction, with UnimplementedPause
+	StopUnimplemented
+)
+
+// Stop tells a debugger why emulation paused itself
+type Stop struct {
+	Reason StopReason
+
+	// id of the breakpoint, for StopBreakpoint
+	Breakpoint int
+
+	// the read or write that hit a watchpoint, nil for other stops
+	Access *Access
+
+	// the return that hit a BreakBadReturn breakpoint
+	BadReturn *BadReturn
+
+	// the instruction, for StopUnimplemented
+	Unimplemented *UnimplementedError
+
+	PC    uint16
+	Frame uint64
+}
+
+// Registers is a copy of the cpu registers, F holds the flags
+type Registers struct {
+	A, F, B, C, D, E, H, L byte
+	SP, PC                 uint16
+}
+
+type debugState struct {
+	// whether there is anything to check between instructions
+	active bool
+
+	breakpoints map[int]Breakpoint
+	nextID      int
+
+	hooks      map[int]watch
+	nextHookID int
+
+	// the breakpoints and hooks checked on each access, by id
+	watches        []watch
+	watchesExecute bool
+
+	// 0 when not running to a frame
+	untilFrame uint64
+
+	// breakpoints reached by the current instruction, or before the next
+	hits []hit
+
+	// set on resume, so a pc breakpoint doesn't hit again straight away
+	skipPC bool
+
+	// likewise for the unimplemented instruction stopped at
+	skipUnimplemented bool
+
+	stops chan Stop
+}
+
+func newDebugState() *debugState {
+	return &debugState{
+		breakpoints: make(map[int]Breakpoint),
+		nextID:      1,
+		hooks:       make(map[int]watch),
+		nextHookID:  1,
+		stops:       make(chan Stop, 1),
+	}
+}
+
+// Decode returns the instruction that code starts with
+func Decode(code []byte) (Instruction, bool) {
+	if len(code) == 0 {
+		return Instruction{}, false
+	}
+
+	if (code[0] == 0x10 || code[0] == 0xcb) && len(code) > 1 {
+		instruction, ok := InstructionTable16[makeWord(code[0], code[1])]
+		return instruction, ok
+	}
+
+	instruction, ok := InstructionTable8[code[0]]
+	return instruction, ok
+}
+
+// Size is the length of the instruction in bytes, including operands
+func (i Instruction) Size() int {
+	return i.size
+}
+
+// Stops delivers a Stop every time a breakpoint or RunToFrame pauses emulation.
+// if nobody is receiving, the latest one waits, until emulation is resumed
+// or reverse continued and it's dropped, so a stop is never received after
+// the one it was waited for
+func (gb *Gb) Stops() <-chan Stop {
+	return gb.debug.stops
+}
+
+// AddBreakpoint returns an id for RemoveBreakpoint
+func (gb *Gb) AddBreakpoint(bp Breakpoint) (id int) {
+	gb.do(func() {
+		id = gb.debug.nextID
+		gb.debug.nextID++
+		bp.Hits = 0
+		gb.debug.breakpoints[id] = bp
+		gb.updateDebug()
+	})
+	return id
+}
+
+// RemoveBreakpoint reports whether there was a breakpoint with id
+func (gb *Gb) RemoveBreakpoint(id int) (ok bool) {
+	gb.do(func() {
+		_, ok = gb.debug.breakpoints[id]
+		delete(gb.debug.breakpoints, id)
+		gb.updateDebug()
+	})
+	return ok
+}
+
+// Breakpoints returns every breakpoint by id
+func (gb *Gb) Breakpoints() map[int]Breakpoint {
+	bps := make(map[int]Breakpoint)
+	gb.do(func() {
+		for id, bp := range gb.debug.breakpoints {
+			bps[id] = bp
+		}
+	})
+	return bps
+}
+
+// RunToFrame resumes emulation until frame has been reached
+func (gb *Gb) RunToFrame(frame uint64) {
+	gb.do(func() {
+		gb.debug.untilFrame = frame
+		gb.updateDebug()
+		gb.resume()
+	})
+}
+
+// Frame is the number of frames emulated since boot
+func (gb *Gb) Frame() (frame uint64) {
+	gb.do(func() { frame = gb.numFrames })
+	return frame
+}
+
+func (gb *Gb) Registers() (regs Registers) {
+	gb.do(func() {
+		cpu := gb.cpu
+		regs = Registers{
+			A: cpu.a, F: cpu.f(), B: cpu.b, C: cpu.c,
+			D: cpu.d, E: cpu.e, H: cpu.h, L: cpu.l,
+			SP: cpu.sp, PC: cpu.pc,
+		}
+	})
+	return regs
+}
+
+func (gb *Gb) SetRegisters(regs Registers) {
+	gb.do(func() {
+		cpu := gb.cpu
+		cpu.a, cpu.b, cpu.c, cpu.d, cpu.e, cpu.h, cpu.l = regs.A, regs.B, regs.C, regs.D, regs.E, regs.H, regs.L
+		cpu.setF(regs.F)
+		cpu.sp, cpu.pc = regs.SP, regs.PC
+	})
+}
+
+// only watch memory while there are memory breakpoints or hooks,
+// so normal emulation doesn't pay for it
+func (gb *Gb) updateDebug() {
+	d := gb.debug
+	d.active = len(d.breakpoints) > 0 || len(d.hooks) > 0 || d.untilFrame > 0
+
+	d.watches = nil
+	for id, bp := range d.breakpoints {
+		if kinds := bp.accesses(); kinds != 0 {
+			d.watches = append(d.watches, watch{kinds: kinds, from: bp.Addr, to: bp.last(), id: id, cond: bp.Cond, value: bp.Value})
+		}
+	}
+	for id, hook := range d.hooks {
+		hook.id = id
+		d.watches = append(d.watches, hook)
+	}
+
+	// breakpoints first, then hooks, in the order they were added
+	sort.Slice(d.watches, func(i, j int) bool {
+		a, b := d.watches[i], d.watches[j]
+		if (a.hook == nil) != (b.hook == nil) {
+			return a.hook == nil
+		}
+		return a.id < b.id
+	})
+
+	// bad returns are found by tracking calls
+	for _, bp := range d.breakpoints {
+		if bp.Kind == BreakBadReturn && gb.cpu.calls == nil {
+			gb.cpu.calls = new(callStack)
+		}
+	}
+
+	d.watchesExecute = false
+	gb.memory.onAccess = nil
+	if gb.cpu.cdl != nil {
+		gb.memory.onAccess = gb.access
+	}
+	for _, w := range d.watches {
+		if w.kinds&AccessExecute != 0 {
+			d.watchesExecute = true
+		}
+		if w.kinds&(AccessRead|AccessWrite) != 0 {
+			gb.memory.onAccess = gb.access
+		}
+	}
+}
+
+// checked before each instruction while debugging
+func (gb *Gb) breakBefore() bool {
+	d := gb.debug
+	gb.reachBefore()
+
+	skip := d.skipPC
+	d.skipPC = false
+	if skip {
+		d.hits = d.hits[:0]
+		return false
+	}
+
+	return gb.breakOnHits()
+}
+
+// notes the breakpoints the next instruction reaches before it runs
+func (gb *Gb) reachBefore() {
+	d := gb.debug
+	gb.execute()
+
+	opcode := gb.memory.opcodeAt(gb.cpu.pc)
+	for id, bp := range d.breakpoints {
+		if (bp.Kind == BreakOpcode && bp.Opcode == opcode) || bp.Kind == BreakExpr {
+			d.hits = append(d.hits, hit{id: id})
+		}
+	}
+}
+
+// notes the breakpoints the last instruction reached, besides watchpoints
+func (gb *Gb) reachAfter() {
+	d := gb.debug
+
+	calls := gb.cpu.calls
+	if calls == nil || !calls.badNow {
+		return
+	}
+
+	for id, bp := range d.breakpoints {
+		if bp.Kind == BreakBadReturn {
+			d.hits = append(d.hits, hit{id: id, ret: calls.bad})
+		}
+	}
+}
+
+// checked after each instruction while debugging
+func (gb *Gb) breakAfter(frameDone bool) bool {
+	d := gb.debug
+	gb.reachAfter()
+
+	if gb.breakOnHits() {
+		return true
+	}
+
+	if frameDone && d.untilFrame > 0 && gb.numFrames >= d.untilFrame {
+		d.untilFrame = 0
+		gb.updateDebug()
+		gb.stop(Stop{Reason: StopFrame})
+		return true
+	}
+
+	return false
+}
+
+func (gb *Gb) stop(s Stop) {
+	gb.clock.paused = true
+	s.PC = gb.cpu.pc
+	s.Frame = gb.numFrames
+
+	gb.debug.dropStop()
+	gb.debug.stops <- s
+}
+
+// forgets a stop nobody received
+func (d *debugState) dropStop() {
+	select {
+	case <-d.stops:
+	default:
+	}
+}
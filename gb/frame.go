@@ -0,0 +1,124 @@
+package gb
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"time"
+)
+
+// ErrClosed is returned by a Renderer to signal that the
+// display was closed by the user and emulation should stop
+var ErrClosed = errors.New("gb: display closed")
+
+// PixelFormat describes the layout of Frame.Pix
+type PixelFormat int
+
+const (
+	// one byte per pixel, shade 0 (lightest) - 3 (darkest)
+	FormatShades PixelFormat = iota
+
+	// two bytes per pixel, little endian 0bbbbbgggggrrrrr
+	FormatRGB555
+
+	// four bytes per pixel, r g b a
+	FormatRGBA
+)
+
+func (f PixelFormat) bytesPerPixel() int {
+	switch f {
+	case FormatRGB555:
+		return 2
+	case FormatRGBA:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// Palette maps dmg shades to colors for the RGB555 and RGBA formats
+var Palette = [4]color.RGBA{
+	{155, 188, 15, 255},
+	{139, 172, 15, 255},
+	{48, 98, 48, 255},
+	{15, 56, 15, 255},
+}
+
+// Frame is one complete lcd frame
+type Frame struct {
+	Width  int
+	Height int
+	Format PixelFormat
+
+	// bytes per row of Pix
+	Stride int
+	Pix    []byte
+
+	// frames emulated since boot, counting this one, like Gb.Frame.
+	// it's 0 only for the blank screen before the first frame
+	Number uint64
+
+	// emulated time at the end of the frame, and how long it lasted
+	Time     time.Duration
+	Duration time.Duration
+}
+
+func newFrame(format PixelFormat) Frame {
+	stride := lcdWidth * format.bytesPerPixel()
+	return Frame{
+		Width:  lcdWidth,
+		Height: lcdHeight,
+		Format: format,
+		Stride: stride,
+		Pix:    make([]byte, stride*lcdHeight),
+	}
+}
+
+// Shade returns the dmg shade of the pixel at x, y.
+// only valid for FormatShades frames
+func (f *Frame) Shade(x int, y int) byte {
+	return f.Pix[y*f.Stride+x]
+}
+
+// Image converts the frame to an image, e.g. for screenshots
+func (f *Frame) Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, f.Width, f.Height))
+
+	for y := 0; y < f.Height; y++ {
+		for x := 0; x < f.Width; x++ {
+			i := y*f.Stride + x*f.Format.bytesPerPixel()
+
+			switch f.Format {
+			case FormatShades:
+				img.SetRGBA(x, y, Palette[f.Pix[i]&0b11])
+			case FormatRGB555:
+				rgb := uint16(f.Pix[i]) | uint16(f.Pix[i+1])<<8
+				expand := func(v uint16) uint8 { return uint8(v&0x1f) << 3 }
+				img.SetRGBA(x, y, color.RGBA{expand(rgb), expand(rgb >> 5), expand(rgb >> 10), 255})
+			case FormatRGBA:
+				copy(img.Pix[y*img.Stride+x*4:], f.Pix[i:i+4])
+			}
+		}
+	}
+
+	return img
+}
+
+// fills the frame from a screen of shades
+func (f *Frame) fill(pixels []Pixel) {
+	for i, px := range pixels {
+		shade := byte(px & 0b11)
+
+		switch f.Format {
+		case FormatShades:
+			f.Pix[i] = shade
+		case FormatRGB555:
+			c := Palette[shade]
+			rgb := uint16(c.R>>3) | uint16(c.G>>3)<<5 | uint16(c.B>>3)<<10
+			f.Pix[i*2], f.Pix[i*2+1] = byte(rgb), byte(rgb>>8)
+		case FormatRGBA:
+			c := Palette[shade]
+			copy(f.Pix[i*4:i*4+4], []byte{c.R, c.G, c.B, c.A})
+		}
+	}
+}
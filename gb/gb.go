@@ -1,7 +1,8 @@
 package gb
 
 import (
-	"io"
+	"context"
+	"errors"
 	"log"
 	"math"
 	"os"
@@ -10,30 +11,73 @@ import (
 
 const refreshHz = float64(cpuHz) / float64(cyclesPerFrame)
 
+var timePerFrame = time.Duration(math.Round(float64(time.Second) / refreshHz))
+
 type Renderer interface {
-	Render([]Pixel)
+	// Format is the pixel format frames are delivered in
+	Format() PixelFormat
+
+	// Render displays a complete frame.  the frame stays valid until
+	// the next call to Render returns, so it is safe to hold onto.
+	// a non-nil error stops emulation, use ErrClosed for a normal shutdown
+	Render(frame *Frame) error
 }
 
 type Gb struct {
 	renderer Renderer
+
+	// double buffered frames handed to the renderer
+	frames    [2]Frame
+	back      int
+	numFrames uint64
+
+	// nil unless EnableRewind was called
+	rewinder *rewinder
+
+	clock    clock
+	commands *commands
+	debug    *debugState
+
+	// what a hard reset fills ram with
+	ramPattern RamPattern
+
+	// where crash reports go, empty unless EnableCrashReports was called
+	crashDir string
+
+	// what to do at unimplemented instructions, and the ones logged
+	unimplemented UnimplementedPolicy
+	logged        map[uint16]bool
+
+	// what Run returns, once emulation stops for it
+	fault error
+
 	*memory
 	*ppu
 	*cpu
+	*joypad
 }
 
 func NewGb() *Gb {
 	gb := new(Gb)
+	gb.clock = newClock()
+	gb.commands = newCommands()
+	gb.debug = newDebugState()
+	gb.unimplemented = UnimplementedLog
+	gb.logged = make(map[uint16]bool)
 	mem := newMemory()
 	ppu := newPpu()
 	cpu := new(cpu)
+	joypad := new(joypad)
 
 	gb.memory = mem
 	gb.ppu = ppu
 	gb.cpu = cpu
+	gb.joypad = joypad
 
 	cpu.memory = mem
 	cpu.ppu = ppu
 	ppu.memory = mem
+	mem.joypad = joypad
 
 	return gb
 }
@@ -46,36 +90,199 @@ func (gb *Gb) LoadCartridge(path string) {
 
 	defer f.Close()
 
-	_, err = io.Copy(gb.memory, f)
+	err = gb.memory.loadRom(f)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+// RomBank is the cartridge bank switched into 0x4000 - 0x7fff.  there's
+// no mbc support yet, so it's always 1
+func (gb *Gb) RomBank() int {
+	return 1
+}
+
 func (gb *Gb) ConnectDisplay(r Renderer) {
 	gb.renderer = r
+	gb.frames[0] = newFrame(r.Format())
+	gb.frames[1] = newFrame(r.Format())
 }
 
+// registers as the dmg boot rom leaves them
 func (gb *Gb) boot() error {
-	gb.cpu.pc = 0x0100
+	cpu := gb.cpu
+	cpu.a, cpu.b, cpu.c, cpu.d, cpu.e, cpu.h, cpu.l = 0x01, 0x00, 0x13, 0x00, 0xd8, 0x01, 0x4d
+	cpu.setF(0xb0)
+	cpu.sp = 0xfffe
+	cpu.pc = 0x0100
+
+	for addr, b := range bootIo {
+		gb.memory.writeByte(addr, b)
+	}
+
 	return nil
 }
 
-func (gb *Gb) mainLoop() {
-	// render at 59.7275 fps
-	timePerFrame := time.Duration(math.Round(float64(time.Second) / refreshHz))
-	c := time.Tick(timePerFrame)
+// swaps the frame buffers and fills the back one from the ppu
+func (gb *Gb) nextFrame() *Frame {
+	frame := &gb.frames[gb.back]
+	gb.back ^= 1
+
+	frame.fill(gb.ppu.pixels)
+	frame.Number = gb.numFrames
+	frame.Time = time.Duration(gb.numFrames) * timePerFrame
+	frame.Duration = timePerFrame
+
+	return frame
+}
+
+// emulates the rest of the current frame.
+// returns false if a breakpoint stopped it early
+func (gb *Gb) runFrame() bool {
+	for {
+		if gb.debug.active && gb.breakBefore() {
+			return false
+		}
+		if gb.unimplemented != UnimplementedIgnore && gb.checkImplemented() {
+			return false
+		}
+
+		frameDone := gb.step()
+		if gb.cpu.lockup != nil && gb.lockedUp() {
+			return false
+		}
+
+		if gb.debug.active && gb.breakAfter(frameDone) {
+			return false
+		}
 
-	for range c {
-		gb.cpu.tick()
-		gb.renderer.Render(gb.ppu.pixels)
+		if frameDone {
+			return true
+		}
 	}
 }
 
-func (gb *Gb) Run() {
-	if err := gb.boot(); err != nil {
-		log.Fatal(err)
+// executes one instruction, returns whether that finished a frame
+func (gb *Gb) step() (frameDone bool) {
+	if gb.cpu.frameCycles == 0 && gb.rewinder != nil {
+		gb.rewinder.record(gb)
+	}
+
+	if !gb.cpu.step() {
+		return false
+	}
+
+	gb.numFrames++
+	return true
+}
+
+func (gb *Gb) mainLoop(ctx context.Context) error {
+	gb.clock.resync()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := gb.fault; err != nil {
+			gb.fault = nil
+			return err
+		}
+
+		gb.runCommands()
+
+		if gb.clock.paused && gb.clock.advance == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-gb.commands.wake:
+			case <-time.After(timePerFrame):
+				// keep rendering so the frontend still sees its input
+				if err := gb.render(ctx); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		pace := gb.clock.pace(gb.clock.perFrame())
+		if pace == paceRepeat {
+			gb.clock.stats.Repeated++
+			if err := gb.presentFrame(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !gb.runFrame() {
+			continue
+		}
+		gb.clock.stats.Emulated++
+
+		render := pace == paceRender && gb.clock.shouldRender()
+		if gb.clock.advance > 0 {
+			gb.clock.advance--
+		}
+
+		if !render {
+			gb.clock.stats.Skipped++
+			continue
+		}
+
+		if err := gb.presentFrame(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// renders and counts the frame for Stats
+func (gb *Gb) presentFrame(ctx context.Context) error {
+	if err := gb.render(ctx); err != nil {
+		return err
+	}
+
+	gb.clock.rendered()
+	return nil
+}
+
+// Render runs on its own goroutine while this one keeps serving
+// commands, so renderers can use the control api without deadlocking
+func (gb *Gb) render(ctx context.Context) error {
+	if gb.renderer == nil {
+		return nil
+	}
+
+	frame := gb.nextFrame()
+	done := make(chan error, 1)
+	go func() { done <- gb.renderer.Render(frame) }()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-gb.commands.wake:
+			gb.runCommands()
+		case <-ctx.Done():
+			// Render finishes on its own, with nobody waiting for it
+			return ctx.Err()
+		}
+	}
+}
+
+// Run boots the cartridge and emulates until ctx is done or the
+// renderer returns an error.  ErrClosed is not treated as an error.
+// while Run is running, the control api is serialized through it
+func (gb *Gb) Run(ctx context.Context) (err error) {
+	if err := gb.commands.start(gb.boot); err != nil {
+		return err
+	}
+	defer gb.commands.stop()
+	defer gb.recoverCrash(&err)
+
+	err = gb.mainLoop(ctx)
+	if errors.Is(err, ErrClosed) {
+		return nil
 	}
 
-	gb.mainLoop()
+	return err
 }
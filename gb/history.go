@@ -0,0 +1,290 @@
+package gb
+
+import (
+	"bytes"
+	"errors"
+)
+
+var ErrHistoryStart = errors.New("gb: reached the start of the history")
+
+// the rewind history doubles as a record for debugging backwards in time.
+// emulation is deterministic given the buttons held each frame, so any
+// moment in it can be revisited by restoring the newest snapshot before
+// it and replaying the recorded input.  that's the buttons held at the
+// start of each frame, so changes made from outside partway through one,
+// like WriteMemory, SetRegisters or a button pressed at a breakpoint,
+// aren't seen by replays.  see Rewind
+
+// a moment in the history that something happened at
+type event struct {
+	// instructions executed by then, and the frame
+	at    uint64
+	frame uint64
+	hit
+}
+
+// Write is a write found in the history
+type Write struct {
+	Access
+
+	// instructions executed just after it, where a write watchpoint
+	// would have stopped, and the frame it happened in
+	Instructions uint64
+	Frame        uint64
+}
+
+// Instructions counts the instructions executed since boot
+func (gb *Gb) Instructions() (n uint64) {
+	gb.do(func() { n = gb.cpu.instructions })
+	return n
+}
+
+// ReverseStep pauses emulation and undoes the last instruction.
+// it needs the history kept by EnableRewind
+func (gb *Gb) ReverseStep() (err error) {
+	gb.do(func() {
+		gb.clock.paused = true
+		if gb.cpu.instructions == 0 {
+			err = ErrHistoryStart
+			return
+		}
+
+		err = gb.seek(gb.cpu.instructions - 1)
+	})
+	return err
+}
+
+// ReverseContinue pauses emulation and goes back to the last moment
+// a breakpoint or watchpoint would have stopped it, sending the Stop it
+// would have sent.  hit counts and tracepoints are left out.  without
+// one in the history, it goes back to the oldest moment and sends a
+// StopHistoryStart
+func (gb *Gb) ReverseContinue() (err error) {
+	gb.do(func() {
+		gb.clock.paused = true
+		gb.debug.dropStop()
+
+		watches := make([]watch, 0, len(gb.debug.watches))
+		for _, w := range gb.debug.watches {
+			if w.hook == nil {
+				watches = append(watches, w)
+			}
+		}
+
+		// stopping here again doesn't count
+		now := gb.cpu.instructions
+		stops := func(ev event) bool { return ev.at < now && gb.wouldStop(ev.hit) }
+
+		var ev event
+		var found bool
+		if ev, found, err = gb.searchBack(watches, true, stops); err != nil {
+			return
+		}
+
+		if !found {
+			if err = gb.seek(gb.rewinder.oldest()); err == nil {
+				gb.stop(Stop{Reason: StopHistoryStart})
+			}
+			return
+		}
+
+		if err = gb.seek(ev.at); err != nil {
+			return
+		}
+
+		stop := Stop{Reason: StopBreakpoint, Breakpoint: ev.id, BadReturn: ev.ret}
+		if ev.access != nil && ev.access.Kind != AccessExecute {
+			stop.Access = ev.access
+		}
+		gb.stop(stop)
+	})
+	return err
+}
+
+// LastWrite searches the history for the last write to addr, without
+// moving emulation.  ok is false if there wasn't one
+func (gb *Gb) LastWrite(addr uint16) (w Write, ok bool, err error) {
+	gb.do(func() {
+		watches := []watch{{kinds: AccessWrite, from: addr, to: addr}}
+		all := func(event) bool { return true }
+
+		var ev event
+		if ev, ok, err = gb.searchBack(watches, false, all); !ok || err != nil {
+			return
+		}
+
+		w = Write{Access: *ev.access, Instructions: ev.at, Frame: ev.frame}
+	})
+	return w, ok, err
+}
+
+// whether h would stop emulation, leaving hit counts out of it
+func (gb *Gb) wouldStop(h hit) bool {
+	bp, ok := gb.debug.breakpoints[h.id]
+	return ok && bp.Log == nil && (bp.Expr == nil || bp.Expr.True(exprEnv{gb}))
+}
+
+// goes back to the moment target instructions had executed
+func (gb *Gb) seek(target uint64) error {
+	r := gb.rewinder
+	if r == nil {
+		return ErrNoRewind
+	}
+
+	if r.newest == nil || target < r.oldest() {
+		return ErrHistoryStart
+	}
+
+	for r.newestInstructions > target && r.size > 0 {
+		r.dropNewest()
+	}
+
+	held := gb.joypad.pressed
+	if err := gb.loadSnapshot(r.newest); err != nil {
+		return err
+	}
+
+	recorded := r.inputs
+	gb.quietly(func() {
+		for gb.cpu.instructions < target {
+			gb.replayStep(r.newestFrame, recorded)
+		}
+	})
+
+	// the frame in progress has had its input recorded already
+	frames := gb.numFrames - r.newestFrame
+	if gb.cpu.frameCycles != 0 {
+		frames++
+	}
+
+	r.inputs = recorded[:frames]
+	gb.joypad.pressed = held
+	return nil
+}
+
+// replays the history from the newest snapshot to the oldest, for the
+// last moment one of watches, or with breakpoints set any other kind of
+// breakpoint, was reached in a way found accepts.  it leaves emulation
+// where it was
+func (gb *Gb) searchBack(watches []watch, breakpoints bool, found func(event) bool) (last event, ok bool, err error) {
+	r := gb.rewinder
+	if r == nil {
+		return event{}, false, ErrNoRewind
+	}
+
+	if r.newest == nil {
+		return event{}, false, nil
+	}
+
+	var now bytes.Buffer
+	gb.saveState(&now)
+	held := gb.joypad.pressed
+
+	var calls []CallFrame
+	if gb.cpu.calls != nil {
+		calls = append(calls, gb.cpu.calls.frames...)
+	}
+
+	d := gb.debug
+	note := func() {
+		for _, h := range d.hits {
+			ev := event{at: gb.cpu.instructions, frame: gb.numFrames, hit: h}
+			if found(ev) {
+				last, ok = ev, true
+			}
+		}
+		d.hits = d.hits[:0]
+	}
+
+	gb.quietly(func() {
+		d.watches = watches
+		d.watchesExecute = false
+		for _, w := range watches {
+			if w.kinds&AccessExecute != 0 {
+				d.watchesExecute = true
+			}
+		}
+		gb.memory.onAccess = gb.access
+
+		end := gb.cpu.instructions
+		state := append([]byte(nil), r.newest...)
+		frame, inputs := r.newestFrame, r.inputs
+
+		for i := r.size; ; i-- {
+			if err = gb.loadSnapshot(state); err != nil {
+				break
+			}
+
+			for gb.cpu.instructions < end {
+				if breakpoints {
+					gb.reachBefore()
+					note()
+				}
+
+				gb.replayStep(frame, inputs)
+				if breakpoints {
+					gb.reachAfter()
+				}
+				note()
+			}
+
+			if ok || i == 0 {
+				break
+			}
+
+			entry := r.entry(i - 1)
+			xorInto(state, unpackZeroRuns(entry.delta, len(state)))
+			end = entry.instructions
+			frame, inputs = entry.frame, entry.inputs
+		}
+
+		d.hits = d.hits[:0]
+		gb.updateDebug()
+	})
+
+	if loadErr := gb.loadSnapshot(now.Bytes()); err == nil {
+		err = loadErr
+	}
+	gb.joypad.pressed = held
+	if gb.cpu.calls != nil {
+		gb.cpu.calls.frames = calls
+	}
+
+	return last, ok, err
+}
+
+// executes the next instruction of the history, holding the buttons
+// recorded for its frame.  snapshot is where inputs start
+func (gb *Gb) replayStep(snapshot uint64, inputs []byte) {
+	if gb.cpu.frameCycles == 0 {
+		if i := gb.numFrames - snapshot; i < uint64(len(inputs)) {
+			gb.joypad.pressed = inputs[i]
+		}
+	}
+
+	if gb.cpu.step() {
+		gb.numFrames++
+	}
+}
+
+// runs f without tracing, profiling, logging code and data, keeping
+// instructions for crash reports or watching memory, so replaying the
+// past doesn't count it or run hooks a second time
+func (gb *Gb) quietly(f func()) {
+	cpu := gb.cpu
+	trace, profile, cdl, recent, onAccess := cpu.trace, cpu.profile, cpu.cdl, cpu.recent, gb.memory.onAccess
+	cpu.trace, cpu.profile, cpu.cdl, cpu.recent, gb.memory.onAccess = nil, nil, nil, nil, nil
+	defer func() {
+		cpu.trace, cpu.profile, cpu.cdl, cpu.recent, gb.memory.onAccess = trace, profile, cdl, recent, onAccess
+	}()
+
+	f()
+}
+
+// instructions executed by the oldest snapshot
+func (r *rewinder) oldest() uint64 {
+	if r.size == 0 {
+		return r.newestInstructions
+	}
+	return r.entry(0).instructions
+}
@@ -0,0 +1,121 @@
+package gb
+
+// AccessKind is what an Access did, as a bit so hooks can ask for several
+type AccessKind int
+
+const (
+	AccessRead AccessKind = 1 << iota
+	AccessWrite
+
+	// fetching an instruction to execute it
+	AccessExecute
+)
+
+// Access is one read, write or instruction fetch on the bus
+type Access struct {
+	Kind AccessKind
+
+	// the instruction making the access
+	PC uint16
+
+	Addr uint16
+
+	// the byte before and after a write.  reads and executes don't
+	// change it, so they're both the byte read or the opcode
+	Old, New byte
+}
+
+// Hook is called for every access of the kinds it was added for,
+// on the goroutine running the Gb, so it mustn't call back into the Gb
+type Hook func(Access)
+
+// a hook or watchpoint, checked against every access while debugging
+type watch struct {
+	kinds    AccessKind
+	from, to uint16
+
+	// set for hooks.  id is the hook's or the breakpoint's
+	hook Hook
+	id   int
+
+	cond  ValueCond
+	value byte
+}
+
+// AddHook calls hook for accesses of kinds to from through to, inclusive,
+// and returns an id for RemoveHook.  while there are no hooks or memory
+// breakpoints the bus doesn't check for them
+func (gb *Gb) AddHook(kinds AccessKind, from, to uint16, hook Hook) (id int) {
+	gb.do(func() {
+		id = gb.debug.nextHookID
+		gb.debug.nextHookID++
+		gb.debug.hooks[id] = watch{kinds: kinds, from: from, to: to, hook: hook}
+		gb.updateDebug()
+	})
+	return id
+}
+
+// RemoveHook reports whether there was a hook with id
+func (gb *Gb) RemoveHook(id int) (ok bool) {
+	gb.do(func() {
+		_, ok = gb.debug.hooks[id]
+		delete(gb.debug.hooks, id)
+		gb.updateDebug()
+	})
+	return ok
+}
+
+// called by memory for every read and write while anything watches them
+func (gb *Gb) access(addr uint16, write bool, old, new byte) {
+	if gb.cpu.cdl != nil {
+		gb.cpu.cdl.access(gb.cpu, addr, write, new)
+	}
+
+	kind := AccessRead
+	if write {
+		kind = AccessWrite
+	}
+
+	gb.debug.check(Access{Kind: kind, PC: gb.cpu.pc, Addr: addr, Old: old, New: new})
+}
+
+// called before each instruction while debugging
+func (gb *Gb) execute() {
+	if gb.debug.watchesExecute {
+		pc := gb.cpu.pc
+		op := gb.memory.fetchByte(pc)
+		gb.debug.check(Access{Kind: AccessExecute, PC: pc, Addr: pc, Old: op, New: op})
+	}
+}
+
+// runs the hooks a matches, and notes the watchpoints it reaches
+func (d *debugState) check(a Access) {
+	for _, w := range d.watches {
+		if w.kinds&a.Kind == 0 || a.Addr < w.from || a.Addr > w.to {
+			continue
+		}
+
+		if w.hook != nil {
+			w.hook(a)
+			continue
+		}
+
+		if w.cond.matches(a, w.value) {
+			access := a
+			d.hits = append(d.hits, hit{id: w.id, access: &access})
+		}
+	}
+}
+
+func (c ValueCond) matches(a Access, value byte) bool {
+	switch c {
+	case ValueEquals:
+		return a.New == value
+	case ValueNotEquals:
+		return a.New != value
+	case ValueChanges:
+		return a.Old != a.New
+	}
+
+	return true
+}
@@ -0,0 +1,48 @@
+package gb
+
+const joypadReg = 0xff00
+
+type Button byte
+
+const (
+	ButtonRight Button = iota
+	ButtonLeft
+	ButtonUp
+	ButtonDown
+	ButtonA
+	ButtonB
+	ButtonSelect
+	ButtonStart
+)
+
+// bit i is set while Button i is held
+type joypad struct {
+	pressed byte
+}
+
+// given the byte last written to 0xff00, returns what the game reads back.
+// bit 4 low selects the d-pad, bit 5 low selects the buttons,
+// and a pressed input reads as 0
+func (j *joypad) read(written byte) byte {
+	keys := byte(0)
+
+	if written&0x10 == 0 {
+		keys |= j.pressed & 0x0f
+	}
+
+	if written&0x20 == 0 {
+		keys |= j.pressed >> 4
+	}
+
+	return 0xc0 | (written & 0x30) | (^keys & 0x0f)
+}
+
+// Press holds down a button
+func (gb *Gb) Press(b Button) {
+	gb.do(func() { gb.joypad.pressed |= 1 << b })
+}
+
+// Release lets go of a button
+func (gb *Gb) Release(b Button) {
+	gb.do(func() { gb.joypad.pressed &^= 1 << b })
+}
@@ -1,20 +1,55 @@
 package gb
 
-import "bytes"
+import "io"
 
+// the whole 16-bit address space
 type memory struct {
-	*bytes.Buffer
+	data   []byte
+	joypad *joypad
+
+	// nil unless a debugger or hook is watching memory
+	onAccess func(addr uint16, write bool, old, new byte)
 }
 
-const memSize = 0xffff
+const (
+	memSize = 0x10000
+	romSize = 0x8000
+)
 
 func newMemory() *memory {
-	buf := make([]byte, 0, memSize)
-	return &memory{bytes.NewBuffer(buf)}
+	return &memory{data: make([]byte, memSize)}
+}
+
+func (m *memory) Bytes() []byte {
+	return m.data
+}
+
+// copies a cartridge rom into 0x0000 - 0x7fff
+func (m *memory) loadRom(r io.Reader) error {
+	_, err := io.ReadFull(r, m.data[:romSize])
+	if err == io.ErrUnexpectedEOF {
+		return nil
+	}
+
+	return err
 }
 
 func (m *memory) readByte(n uint16) byte {
-	return m.Bytes()[n]
+	b := m.fetchByte(n)
+	if m.onAccess != nil {
+		m.onAccess(n, false, b, b)
+	}
+
+	return b
+}
+
+// reads without counting as an access, for instruction fetches
+func (m *memory) fetchByte(n uint16) byte {
+	if n == joypadReg && m.joypad != nil {
+		return m.joypad.read(m.data[n])
+	}
+
+	return m.data[n]
 }
 
 func (m *memory) readWord(n uint16) uint16 {
@@ -24,7 +59,16 @@ func (m *memory) readWord(n uint16) uint16 {
 }
 
 func (m *memory) writeByte(pos uint16, b byte) {
-	m.Bytes()[pos] = b
+	if m.onAccess != nil {
+		m.onAccess(pos, true, m.data[pos], b)
+	}
+
+	m.storeByte(pos, b)
+}
+
+// writes without counting as an access
+func (m *memory) storeByte(pos uint16, b byte) {
+	m.data[pos] = b
 }
 
 func (m *memory) writeWord(pos uint16, word uint16) {
@@ -32,3 +76,13 @@ func (m *memory) writeWord(pos uint16, word uint16) {
 	m.writeByte(pos, lower)
 	m.writeByte(pos+1, upper)
 }
+
+// the opcode at pc, 16 bits for the prefixed instructions
+func (m *memory) opcodeAt(pc uint16) uint16 {
+	b1 := m.fetchByte(pc)
+	if b1 == 0x10 || b1 == 0xcb {
+		return makeWord(b1, m.fetchByte(pc+1))
+	}
+
+	return uint16(b1)
+}
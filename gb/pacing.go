@@ -0,0 +1,212 @@
+package gb
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// most frames in a row that go unrendered to catch up
+	maxFrameSkip = 4
+
+	// further behind than this many frames, the backlog is dropped
+	maxLag = 8
+
+	// frame times kept for Stats, about 2 seconds worth
+	statsWindow = 120
+)
+
+// what the pacer syncs emulated time to
+type SyncMode int
+
+const (
+	// sleep against the wall clock
+	SyncTimer SyncMode = iota
+
+	// Render blocks until vsync, so never sleep.  the display and
+	// the gameboy run at slightly different rates, so frames get
+	// repeated or skipped to stay in step with the wall clock
+	SyncVsync
+
+	// follow a SyncSource, e.g. an audio device draining samples
+	SyncAudio
+)
+
+// SyncSource reports how much emulated time an external consumer,
+// like an audio device, has used up since it started
+type SyncSource interface {
+	Elapsed() time.Duration
+}
+
+// what the main loop should do next
+type pace int
+
+const (
+	paceRender pace = iota
+
+	// emulate, but don't render, to catch up
+	paceSkip
+
+	// render the last frame again, to let the display catch up
+	paceRepeat
+)
+
+// FrameStats describe how well emulation is keeping up
+type FrameStats struct {
+	// totals since the Gb was created
+	Emulated uint64
+	Rendered uint64
+	Skipped  uint64
+	Repeated uint64
+
+	// wall time between rendered frames, over the last couple of seconds
+	Mean   time.Duration
+	Min    time.Duration
+	Max    time.Duration
+	Jitter time.Duration
+}
+
+// pacer tracks emulated time against a reference clock
+// (the wall clock, or a SyncSource), which never drifts
+// the way sleeping a fixed time per frame does
+type pacer struct {
+	mode   SyncMode
+	source SyncSource
+	epoch  time.Time
+
+	// reference time the next frame is due at
+	next time.Duration
+
+	// frames skipped in a row
+	skipped int
+
+	stats      FrameStats
+	frameTimes []time.Duration
+	lastFrame  time.Time
+}
+
+func newPacer() pacer {
+	return pacer{epoch: time.Now()}
+}
+
+// SetSyncMode picks what emulation is paced against.
+// SyncAudio needs a source from SetSyncSource
+func (gb *Gb) SetSyncMode(mode SyncMode) {
+	gb.do(func() {
+		gb.clock.mode = mode
+		gb.clock.resync()
+	})
+}
+
+// SetSyncSource sets what SyncAudio follows
+func (gb *Gb) SetSyncSource(source SyncSource) {
+	gb.do(func() {
+		gb.clock.source = source
+		gb.clock.resync()
+	})
+}
+
+// Stats returns frame timing statistics
+func (gb *Gb) Stats() (stats FrameStats) {
+	gb.do(func() { stats = gb.clock.frameStats() })
+	return stats
+}
+
+// time on the reference clock
+func (p *pacer) now() time.Duration {
+	if p.mode == SyncAudio && p.source != nil {
+		return p.source.Elapsed()
+	}
+
+	return time.Since(p.epoch)
+}
+
+// makes the next frame due now, forgetting any lag
+func (p *pacer) resync() {
+	p.next = p.now()
+	p.skipped = 0
+	p.lastFrame = time.Time{}
+}
+
+// waits until the next frame is due and decides what to do with it.
+// perFrame is 0 to run as fast as possible
+func (p *pacer) pace(perFrame time.Duration) pace {
+	if perFrame == 0 {
+		p.resync()
+		return paceRender
+	}
+
+	now := p.now()
+
+	if p.mode == SyncVsync {
+		// rendering waits for the display, so never sleep
+		if p.next-now > perFrame {
+			return paceRepeat
+		}
+	} else {
+		for now < p.next {
+			time.Sleep(p.next - now)
+			now = p.now()
+		}
+	}
+
+	behind := now - p.next
+	p.next += perFrame
+
+	if behind > maxLag*perFrame {
+		p.resync()
+		return paceRender
+	}
+
+	if behind > perFrame && p.skipped < maxFrameSkip {
+		p.skipped++
+		return paceSkip
+	}
+
+	p.skipped = 0
+	return paceRender
+}
+
+// called every time a frame is rendered
+func (p *pacer) rendered() {
+	now := time.Now()
+
+	if !p.lastFrame.IsZero() {
+		if len(p.frameTimes) == statsWindow {
+			p.frameTimes = p.frameTimes[1:]
+		}
+		p.frameTimes = append(p.frameTimes, now.Sub(p.lastFrame))
+	}
+
+	p.lastFrame = now
+	p.stats.Rendered++
+}
+
+func (p *pacer) frameStats() FrameStats {
+	stats := p.stats
+	if len(p.frameTimes) == 0 {
+		return stats
+	}
+
+	var sum time.Duration
+	stats.Min = p.frameTimes[0]
+	for _, t := range p.frameTimes {
+		sum += t
+		if t < stats.Min {
+			stats.Min = t
+		}
+		if t > stats.Max {
+			stats.Max = t
+		}
+	}
+	stats.Mean = sum / time.Duration(len(p.frameTimes))
+
+	var variance float64
+	for _, t := range p.frameTimes {
+		d := float64(t - stats.Mean)
+		variance += d * d
+	}
+	stats.Jitter = time.Duration(math.Sqrt(variance / float64(len(p.frameTimes))))
+
+	return stats
+}
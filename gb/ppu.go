@@ -149,7 +149,7 @@ func newPixel(bit1 bool, bit2 bool) (pixel Pixel) {
 }
 
 func newPpu() *ppu {
-	ppu := ppu{}
+	ppu := ppu{pixels: make([]Pixel, numPixels)}
 
 	ppu.lcdc = memReg{&ppu, 0xff40}
 	ppu.lcds = memReg{&ppu, 0xff41}
@@ -0,0 +1,194 @@
+package gb
+
+import (
+	"sort"
+	"time"
+
+	"github.com/justinawrey/goboy/symbols"
+)
+
+// Profile is where emulation spent its cycles while profiling
+type Profile struct {
+	Samples []ProfileSample
+
+	// frames emulated while profiling, and how long that is on hardware
+	Frames   uint64
+	Duration time.Duration
+}
+
+// ProfileSample counts an instruction reached through one chain of calls
+type ProfileSample struct {
+	// the instruction, then the call sites that led to it, innermost
+	// first.  without call tracking, just the instruction
+	Stack []ProfileLocation
+
+	Instructions uint64
+	Cycles       uint64
+}
+
+// ProfileLocation is an instruction in a rom bank
+type ProfileLocation struct {
+	Bank int
+	Addr uint16
+
+	// where the call it's in went, if call tracking knows of one
+	RoutineBank int
+	Routine     uint16
+	InRoutine   bool
+}
+
+// counts exactly, per instruction and chain of calls
+type profiler struct {
+	romBank func() int
+	calls   *callStack
+
+	// chains of calls, interned, and the one calls is at
+	chains  []profileChain
+	chainID map[string]int
+	chain   int
+	version uint64
+
+	counts map[profileKey]*ProfileSample
+
+	startFrame uint64
+	frame      *uint64
+}
+
+type profileChain struct {
+	// innermost first
+	sites []ProfileLocation
+
+	// where the innermost call went
+	target ProfileLocation
+}
+
+type profileKey struct {
+	chain int
+	bank  int
+	addr  uint16
+}
+
+// StartProfile counts the instructions and cycles executed from now on.
+// with EnableCallStack, they're counted per chain of calls too.  a
+// profile that was already running is thrown away
+func (gb *Gb) StartProfile() {
+	gb.do(func() {
+		gb.cpu.profile = &profiler{
+			romBank:    gb.RomBank,
+			chains:     []profileChain{{}},
+			chainID:    map[string]int{"": 0},
+			counts:     make(map[profileKey]*ProfileSample),
+			startFrame: gb.numFrames,
+			frame:      &gb.numFrames,
+		}
+	})
+}
+
+// StopProfile returns what was counted since StartProfile, or nil
+// if it wasn't called
+func (gb *Gb) StopProfile() (p *Profile) {
+	gb.do(func() {
+		if gb.cpu.profile != nil {
+			p = gb.cpu.profile.profile()
+			gb.cpu.profile = nil
+		}
+	})
+	return p
+}
+
+// called before every instruction while profiling, with its cycles
+func (p *profiler) add(cpu *cpu, pc uint16, cycles int) {
+	if cpu.calls != p.calls || (cpu.calls != nil && cpu.calls.version != p.version) {
+		p.enter(cpu.calls)
+	}
+
+	key := profileKey{chain: p.chain, bank: symbols.BankAt(pc, p.romBank()), addr: pc}
+	s, ok := p.counts[key]
+	if !ok {
+		s = new(ProfileSample)
+		p.counts[key] = s
+	}
+
+	s.Instructions++
+	s.Cycles += uint64(cycles)
+}
+
+// looks up the chain of calls the call stack is at now
+func (p *profiler) enter(calls *callStack) {
+	p.calls, p.chain = calls, 0
+	if calls == nil {
+		return
+	}
+
+	p.version = calls.version
+	if len(calls.frames) == 0 {
+		return
+	}
+
+	frames := calls.frames
+	key := make([]byte, 0, len(frames)*4)
+	for _, frame := range frames {
+		key = append(key, byte(frame.Call), byte(frame.Call>>8), byte(frame.Target), byte(frame.Target>>8))
+	}
+
+	id, ok := p.chainID[string(key)]
+	if !ok {
+		id = len(p.chains)
+		p.chainID[string(key)] = id
+		p.chains = append(p.chains, profileChain{sites: p.callSites(frames), target: p.routine(frames[len(frames)-1])})
+	}
+	p.chain = id
+}
+
+// the call sites of frames innermost first, each in the routine the
+// frame outside it called
+func (p *profiler) callSites(frames []CallFrame) []ProfileLocation {
+	sites := make([]ProfileLocation, len(frames))
+
+	for i := range frames {
+		frame := frames[len(frames)-1-i]
+		site := ProfileLocation{Bank: symbols.BankAt(frame.Call, p.romBank()), Addr: frame.Call}
+
+		if outer := len(frames) - 2 - i; outer >= 0 {
+			target := p.routine(frames[outer])
+			site.RoutineBank, site.Routine, site.InRoutine = target.RoutineBank, target.Routine, true
+		}
+		sites[i] = site
+	}
+
+	return sites
+}
+
+// where frame's call went, as the routine of the code it runs
+func (p *profiler) routine(frame CallFrame) ProfileLocation {
+	return ProfileLocation{RoutineBank: symbols.BankAt(frame.Target, p.romBank()), Routine: frame.Target, InRoutine: true}
+}
+
+func (p *profiler) profile() *Profile {
+	frames := *p.frame - p.startFrame
+	prof := &Profile{
+		Frames:   frames,
+		Duration: time.Duration(frames) * timePerFrame,
+	}
+
+	for key, s := range p.counts {
+		chain := p.chains[key.chain]
+
+		leaf := chain.target
+		leaf.Bank, leaf.Addr = key.bank, key.addr
+
+		s.Stack = append([]ProfileLocation{leaf}, chain.sites...)
+		prof.Samples = append(prof.Samples, *s)
+	}
+
+	// busiest first
+	sort.Slice(prof.Samples, func(i, j int) bool {
+		a, b := prof.Samples[i], prof.Samples[j]
+		if a.Cycles != b.Cycles {
+			return a.Cycles > b.Cycles
+		}
+		return a.Stack[0].Addr < b.Stack[0].Addr
+	})
+
+	return prof
+}
@@ -0,0 +1,100 @@
+package gb
+
+import (
+	"math/rand"
+	"time"
+)
+
+// what ram holds after a hard reset
+type RamPattern int
+
+const (
+	RamZero RamPattern = iota
+	RamRandom
+
+	// 0x00 and 0xff in alternating runs of 8 bytes, flipping every
+	// 0x200 bytes, roughly what dmg ram powers up to
+	RamDmg
+)
+
+// memory regions a hard reset re-initializes.
+// cartridge ram (0xa000 - 0xbfff) is battery backed, so it is kept
+var (
+	wram = [2]int{0xc000, 0xe000}
+	hram = [2]int{0xff80, 0xffff}
+
+	// these are cleared outright
+	vram = [2]int{0x8000, 0xa000}
+	oam  = [2]int{0xfe00, 0xfea0}
+)
+
+// io registers as the dmg boot rom leaves them
+var bootIo = map[uint16]byte{
+	0xff00: 0xcf, // joyp
+	0xff05: 0x00, // tima
+	0xff06: 0x00, // tma
+	0xff07: 0x00, // tac
+	0xff0f: 0xe1, // if
+	0xff40: 0x91, // lcdc
+	0xff41: 0x85, // stat
+	0xff42: 0x00, // scy
+	0xff43: 0x00, // scx
+	0xff44: 0x00, // ly
+	0xff45: 0x00, // lyc
+	0xff47: 0xfc, // bgp
+	0xff4a: 0x00, // wy
+	0xff4b: 0x00, // wx
+	0xffff: 0x00, // ie
+}
+
+// SetRamPattern picks what ram is filled with on a hard reset
+func (gb *Gb) SetRamPattern(p RamPattern) {
+	gb.do(func() { gb.ramPattern = p })
+}
+
+// a soft reset runs the boot sequence again, like the reset button
+// combination games check for.  a hard reset is a power cycle:
+// ram is re-initialized too, only cartridge ram survives
+func (gb *Gb) reset(hard bool) {
+	if hard {
+		mem := gb.memory.Bytes()
+		fillRam(mem[wram[0]:wram[1]], gb.ramPattern)
+		fillRam(mem[hram[0]:hram[1]], gb.ramPattern)
+		fillRam(mem[vram[0]:vram[1]], RamZero)
+		fillRam(mem[oam[0]:oam[1]], RamZero)
+	}
+
+	gb.cpu.frameCycles = 0
+	gb.cpu.scanCycles = 0
+	gb.cpu.locked, gb.cpu.lockup = false, nil
+	gb.cpu.calls.clear()
+	for i := range gb.ppu.pixels {
+		gb.ppu.pixels[i] = 0
+	}
+
+	gb.clearHistory()
+	gb.boot()
+}
+
+func fillRam(ram []byte, p RamPattern) {
+	switch p {
+	case RamRandom:
+		rand.New(rand.NewSource(time.Now().UnixNano())).Read(ram)
+	case RamDmg:
+		for i := range ram {
+			on := (i/8)%2 == 0
+			if (i/0x200)%2 == 1 {
+				on = !on
+			}
+
+			ram[i] = 0x00
+			if on {
+				ram[i] = 0xff
+			}
+		}
+	default:
+		for i := range ram {
+			ram[i] = 0
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package gb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var ErrNoRewind = errors.New("gb: rewind is not enabled")
+
+// ErrRewindInputs is returned by Rewind when the recorded input runs out
+// before the frame it was rewinding to, which it stops short of
+var ErrRewindInputs = errors.New("gb: rewind history is missing input")
+
+// one older snapshot in the rewind history
+type rewindEntry struct {
+	frame        uint64
+	instructions uint64
+
+	// the snapshot xor'd with the next newer one, zero-run compressed
+	delta []byte
+
+	// joypad state for every frame from this snapshot up to the next one
+	inputs []byte
+}
+
+// rewinder keeps a ring buffer of periodic save states.
+// only the newest state is kept whole, older states are stored as
+// compressed deltas against their successor, and unpacked newest first
+type rewinder struct {
+	granularity int
+
+	// ring of entries, oldest at start
+	entries []rewindEntry
+	start   int
+	size    int
+
+	newest             []byte
+	newestFrame        uint64
+	newestInstructions uint64
+
+	// joypad state for every frame since newest
+	inputs []byte
+}
+
+// EnableRewind starts recording a history of seconds for Rewind,
+// snapshotting every granularity frames.  seconds of 0 disables rewind.
+// the only input recorded is the buttons held at the start of each frame
+func (gb *Gb) EnableRewind(seconds float64, granularity int) {
+	gb.do(func() { gb.enableRewind(seconds, granularity) })
+}
+
+func (gb *Gb) enableRewind(seconds float64, granularity int) {
+	if seconds <= 0 {
+		gb.rewinder = nil
+		return
+	}
+
+	if granularity < 1 {
+		granularity = 1
+	}
+
+	snapshots := int(math.Ceil(seconds * refreshHz / float64(granularity)))
+	gb.rewinder = &rewinder{
+		granularity: granularity,
+		entries:     make([]rewindEntry, snapshots),
+	}
+}
+
+// loading a state or resetting leaves the history leading somewhere else
+func (gb *Gb) clearHistory() {
+	if gb.rewinder != nil {
+		gb.rewinder.clear()
+	}
+}
+
+// Rewind steps emulation back by frames, restoring the nearest snapshot
+// and re-running recorded input up to the exact frame.  it stops at the
+// oldest frame still in the history.  buttons pressed or released partway
+// through a frame, like while paused at a breakpoint, are replayed from
+// the start of the next one, and WriteMemory and SetRegisters aren't
+// replayed at all, so after either the frames rewound to can differ from
+// the ones first emulated
+func (gb *Gb) Rewind(frames int) (err error) {
+	gb.do(func() { err = gb.rewind(frames) })
+	return err
+}
+
+func (gb *Gb) rewind(frames int) error {
+	r := gb.rewinder
+	if r == nil {
+		return ErrNoRewind
+	}
+
+	if r.newest == nil || frames <= 0 {
+		return nil
+	}
+
+	target := uint64(0)
+	if uint64(frames) < gb.numFrames {
+		target = gb.numFrames - uint64(frames)
+	}
+
+	// unpack older snapshots until one is at or before the target
+	for r.newestFrame > target && r.size > 0 {
+		r.dropNewest()
+	}
+
+	if target < r.newestFrame {
+		target = r.newestFrame
+	}
+
+	// re-simulate up to target with the input that was recorded,
+	// then go back to whatever is being held right now
+	held := gb.joypad.pressed
+	if err := gb.loadSnapshot(r.newest); err != nil {
+		return err
+	}
+
+	var err error
+	n := target - r.newestFrame
+	if n > uint64(len(r.inputs)) {
+		n, err = uint64(len(r.inputs)), ErrRewindInputs
+	}
+	replay := r.inputs[:n]
+
+	// these frames were traced and profiled the first time
+	gb.quietly(func() {
+		for _, pressed := range replay {
+			gb.joypad.pressed = pressed
+			gb.cpu.tick()
+			gb.numFrames++
+		}
+	})
+
+	r.inputs = replay
+	gb.joypad.pressed = held
+	return err
+}
+
+// called before every emulated frame
+func (r *rewinder) record(gb *Gb) {
+	// after a clear there's nothing to go back to until the next
+	// snapshot, so that's taken straight away
+	due := gb.numFrames%uint64(r.granularity) == 0
+	if r.newest == nil || due && gb.numFrames != r.newestFrame {
+		r.snapshot(gb)
+	}
+
+	r.inputs = append(r.inputs, gb.joypad.pressed)
+}
+
+func (r *rewinder) snapshot(gb *Gb) {
+	var buf bytes.Buffer
+	gb.saveState(&buf)
+	state := buf.Bytes()
+
+	// e.g. after loading a different cartridge, the old history is useless
+	if len(state) != len(r.newest) {
+		r.start, r.size = 0, 0
+		r.newest = nil
+	}
+
+	if r.newest != nil {
+		xorInto(r.newest, state)
+		r.push(rewindEntry{
+			frame:        r.newestFrame,
+			instructions: r.newestInstructions,
+			delta:        packZeroRuns(r.newest),
+			inputs:       r.inputs,
+		})
+	}
+
+	r.newest = state
+	r.newestFrame = gb.numFrames
+	r.newestInstructions = gb.cpu.instructions
+	r.inputs = nil
+}
+
+// forgets the history, when emulation jumps somewhere it doesn't lead
+func (r *rewinder) clear() {
+	for i := range r.entries {
+		r.entries[i] = rewindEntry{}
+	}
+	r.start, r.size = 0, 0
+	r.newest, r.inputs = nil, nil
+}
+
+// adds to the newest end, dropping the oldest entry when full
+func (r *rewinder) push(entry rewindEntry) {
+	if r.size == len(r.entries) {
+		r.start = (r.start + 1) % len(r.entries)
+		r.size--
+	}
+
+	r.entries[(r.start+r.size)%len(r.entries)] = entry
+	r.size++
+}
+
+// unpacks the snapshot before the newest one, dropping the newest
+func (r *rewinder) dropNewest() {
+	entry := r.pop()
+	xorInto(r.newest, unpackZeroRuns(entry.delta, len(r.newest)))
+	r.newestFrame = entry.frame
+	r.newestInstructions = entry.instructions
+	r.inputs = entry.inputs
+}
+
+// the ith entry, oldest first
+func (r *rewinder) entry(i int) rewindEntry {
+	return r.entries[(r.start+i)%len(r.entries)]
+}
+
+// removes from the newest end
+func (r *rewinder) pop() rewindEntry {
+	r.size--
+	i := (r.start + r.size) % len(r.entries)
+	entry := r.entries[i]
+	r.entries[i] = rewindEntry{}
+	return entry
+}
+
+func (gb *Gb) loadSnapshot(state []byte) error {
+	return gb.loadState(bytes.NewReader(state))
+}
+
+func xorInto(dst []byte, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// deltas between consecutive snapshots are mostly zeroes, so they are
+// stored as pairs of (zero run length, literal length) uvarints
+// each followed by the literal bytes
+func packZeroRuns(data []byte) []byte {
+	var packed []byte
+	varint := make([]byte, binary.MaxVarintLen64)
+
+	for i := 0; i < len(data); {
+		zeroes := 0
+		for i+zeroes < len(data) && data[i+zeroes] == 0 {
+			zeroes++
+		}
+		i += zeroes
+
+		literal := 0
+		for i+literal < len(data) && data[i+literal] != 0 {
+			literal++
+		}
+
+		n := binary.PutUvarint(varint, uint64(zeroes))
+		packed = append(packed, varint[:n]...)
+		n = binary.PutUvarint(varint, uint64(literal))
+		packed = append(packed, varint[:n]...)
+		packed = append(packed, data[i:i+literal]...)
+		i += literal
+	}
+
+	return packed
+}
+
+func unpackZeroRuns(packed []byte, size int) []byte {
+	data := make([]byte, size)
+	r := bytes.NewReader(packed)
+
+	for i := 0; r.Len() > 0; {
+		zeroes, _ := binary.ReadUvarint(r)
+		literal, _ := binary.ReadUvarint(r)
+		i += int(zeroes)
+		r.Read(data[i : i+int(literal)])
+		i += int(literal)
+	}
+
+	return data
+}
@@ -0,0 +1,59 @@
+package gb
+
+import "fmt"
+
+// StepResult is what SingleStep's instruction did
+type StepResult struct {
+	// after it executed
+	Registers
+
+	// the bus writes it made, in order
+	Writes []Access
+
+	Cycles int
+
+	// why it didn't execute properly: an illegal opcode, or a panic
+	Err error
+}
+
+// SingleStep executes the instruction at regs.PC on a cpu of its own,
+// with mem, all 64k of it, as a flat address space with nothing else
+// attached: no ppu, joypad or io.  it's for checking instructions against
+// test vectors, and changes mem
+func SingleStep(regs Registers, mem []byte) (res StepResult) {
+	if len(mem) != memSize {
+		res.Err = fmt.Errorf("gb: single step memory is %d bytes, not %d", len(mem), memSize)
+		return res
+	}
+
+	m := &memory{data: mem}
+	cpu := &cpu{memory: m}
+	cpu.a, cpu.b, cpu.c, cpu.d, cpu.e, cpu.h, cpu.l = regs.A, regs.B, regs.C, regs.D, regs.E, regs.H, regs.L
+	cpu.setF(regs.F)
+	cpu.sp, cpu.pc = regs.SP, regs.PC
+
+	m.onAccess = func(addr uint16, write bool, old, new byte) {
+		if write {
+			res.Writes = append(res.Writes, Access{Kind: AccessWrite, PC: regs.PC, Addr: addr, Old: old, New: new})
+		}
+	}
+
+	// an instruction reaching for the ppu finds it missing
+	defer func() {
+		if r := recover(); r != nil {
+			res.Err = fmt.Errorf("gb: %s panicked: %v", cpu.decode().Mnemonic, r)
+		}
+	}()
+
+	res.Cycles = cpu.executeInstruction()
+	if cpu.locked {
+		res.Err = cpu.lockup
+	}
+
+	res.Registers = Registers{
+		A: cpu.a, F: cpu.f(), B: cpu.b, C: cpu.c,
+		D: cpu.d, E: cpu.e, H: cpu.h, L: cpu.l,
+		SP: cpu.sp, PC: cpu.pc,
+	}
+	return res
+}
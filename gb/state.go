@@ -0,0 +1,246 @@
+package gb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// save state layout (little endian):
+//
+//	"GBSS"        magic
+//	uint16        format version
+//	chunks...     4 byte id, uint32 length, data
+//
+// unknown chunks are skipped, so newer components can be added as
+// chunks without breaking old readers.  states from older versions
+// are upgraded through stateMigrations before they are loaded
+const (
+	stateMagic   = "GBSS"
+	stateVersion = 1
+)
+
+var (
+	ErrBadState     = errors.New("gb: not a save state")
+	ErrStateVersion = errors.New("gb: save state is from a newer goboy")
+)
+
+// no chunk is bigger than memory, with room to grow.  anything longer is
+// a corrupt state, not worth allocating for
+const maxChunkLen = 2 * memSize
+
+type stateChunk struct {
+	id string
+
+	// every chunk is this long, which is checked for all of them before
+	// any is loaded, so a bad state doesn't leave the Gb half loaded
+	size int
+
+	save func(gb *Gb) []byte
+	load func(gb *Gb, data []byte)
+}
+
+// every piece of emulator state, in the order it is written.  goboy
+// doesn't emulate timers, sound, mappers and their rtcs or interrupts
+// yet, so there's nothing of theirs to save beyond their io registers,
+// which are in MEM.  they'll need chunks of their own when they are
+var stateChunks = []stateChunk{
+	{"GB  ", 16, (*Gb).saveGb, (*Gb).loadGb},
+	{"CPU ", 12, (*Gb).saveCpu, (*Gb).loadCpu},
+	{"CLK ", 8, (*Gb).saveClk, (*Gb).loadClk},
+	{"MEM ", memSize, (*Gb).saveMem, (*Gb).loadMem},
+	{"PPU ", numPixels, (*Gb).savePpu, (*Gb).loadPpu},
+	{"JOYP", 1, (*Gb).saveJoypad, (*Gb).loadJoypad},
+}
+
+// stateMigrations[v] upgrades the chunks of a version v state to version
+// v+1.  there's only been the one version so far
+var stateMigrations = map[int]func(chunks map[string][]byte) error{}
+
+// SaveState writes a snapshot of the Gb to w
+func (gb *Gb) SaveState(w io.Writer) (err error) {
+	gb.do(func() { err = gb.saveState(w) })
+	return err
+}
+
+// LoadState restores a snapshot written by SaveState,
+// migrating it forward first if it is from an older version
+func (gb *Gb) LoadState(r io.Reader) (err error) {
+	gb.do(func() {
+		if err = gb.loadState(r); err == nil {
+			gb.clearHistory()
+		}
+	})
+	return err
+}
+
+func (gb *Gb) saveState(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(stateMagic)
+	binary.Write(bw, binary.LittleEndian, uint16(stateVersion))
+
+	for _, chunk := range stateChunks {
+		data := chunk.save(gb)
+		bw.WriteString(chunk.id)
+		binary.Write(bw, binary.LittleEndian, uint32(len(data)))
+		bw.Write(data)
+	}
+
+	return bw.Flush()
+}
+
+func (gb *Gb) loadState(r io.Reader) error {
+	version, chunks, err := readState(r)
+	if err != nil {
+		return err
+	}
+
+	for ; version < stateVersion; version++ {
+		migrate, ok := stateMigrations[version]
+		if !ok {
+			return fmt.Errorf("gb: no migration from save state version %d", version)
+		}
+		if err := migrate(chunks); err != nil {
+			return fmt.Errorf("gb: migrating save state from version %d: %w", version, err)
+		}
+	}
+
+	for _, chunk := range stateChunks {
+		data, ok := chunks[chunk.id]
+		if !ok {
+			return fmt.Errorf("gb: save state has no %q chunk", chunk.id)
+		}
+
+		if err := checkLen(data, chunk.size); err != nil {
+			return fmt.Errorf("gb: loading %q chunk: %w", chunk.id, err)
+		}
+	}
+
+	for _, chunk := range stateChunks {
+		chunk.load(gb, chunks[chunk.id])
+	}
+
+	// the calls that led to the old state don't lead to the new one
+	gb.cpu.calls.clear()
+	return nil
+}
+
+func readState(r io.Reader) (version int, chunks map[string][]byte, err error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:4]) != stateMagic {
+		return 0, nil, ErrBadState
+	}
+
+	version = int(binary.LittleEndian.Uint16(header[4:]))
+	if version > stateVersion {
+		return 0, nil, ErrStateVersion
+	}
+
+	chunks = make(map[string][]byte)
+	for {
+		chunkHeader := make([]byte, 8)
+		_, err := io.ReadFull(br, chunkHeader)
+		if err == io.EOF {
+			return version, chunks, nil
+		}
+		if err != nil {
+			return 0, nil, ErrBadState
+		}
+
+		n := binary.LittleEndian.Uint32(chunkHeader[4:])
+		if n > maxChunkLen {
+			return 0, nil, ErrBadState
+		}
+
+		data := make([]byte, n)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return 0, nil, ErrBadState
+		}
+
+		chunks[string(chunkHeader[:4])] = data
+	}
+}
+
+func checkLen(data []byte, n int) error {
+	if len(data) != n {
+		return fmt.Errorf("expected %d bytes, got %d", n, len(data))
+	}
+	return nil
+}
+
+func (gb *Gb) saveGb() []byte {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint64(data, gb.numFrames)
+	binary.LittleEndian.PutUint64(data[8:], gb.cpu.instructions)
+	return data
+}
+
+func (gb *Gb) loadGb(data []byte) {
+	gb.numFrames = binary.LittleEndian.Uint64(data)
+	gb.cpu.instructions = binary.LittleEndian.Uint64(data[8:])
+}
+
+func (gb *Gb) saveCpu() []byte {
+	cpu := gb.cpu
+	data := []byte{cpu.a, cpu.f(), cpu.b, cpu.c, cpu.d, cpu.e, cpu.h, cpu.l, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(data[8:], cpu.sp)
+	binary.LittleEndian.PutUint16(data[10:], cpu.pc)
+	return data
+}
+
+func (gb *Gb) loadCpu(data []byte) {
+	cpu := gb.cpu
+	cpu.a, cpu.b, cpu.c, cpu.d, cpu.e, cpu.h, cpu.l = data[0], data[2], data[3], data[4], data[5], data[6], data[7]
+	cpu.setF(data[1])
+	cpu.sp = binary.LittleEndian.Uint16(data[8:])
+	cpu.pc = binary.LittleEndian.Uint16(data[10:])
+
+	// a locked up cpu is at its illegal opcode, and locks up again
+	cpu.locked, cpu.lockup = false, nil
+}
+
+func (gb *Gb) saveClk() []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data, uint32(gb.cpu.frameCycles))
+	binary.LittleEndian.PutUint32(data[4:], uint32(gb.cpu.scanCycles))
+	return data
+}
+
+func (gb *Gb) loadClk(data []byte) {
+	gb.cpu.frameCycles = int(binary.LittleEndian.Uint32(data))
+	gb.cpu.scanCycles = int(binary.LittleEndian.Uint32(data[4:]))
+}
+
+func (gb *Gb) saveMem() []byte {
+	return append([]byte(nil), gb.memory.Bytes()...)
+}
+
+func (gb *Gb) loadMem(data []byte) {
+	copy(gb.memory.Bytes(), data)
+}
+
+func (gb *Gb) savePpu() []byte {
+	data := make([]byte, len(gb.ppu.pixels))
+	for i, px := range gb.ppu.pixels {
+		data[i] = byte(px)
+	}
+	return data
+}
+
+func (gb *Gb) loadPpu(data []byte) {
+	for i, px := range data {
+		gb.ppu.pixels[i] = Pixel(px)
+	}
+}
+
+func (gb *Gb) saveJoypad() []byte {
+	return []byte{gb.joypad.pressed}
+}
+
+func (gb *Gb) loadJoypad(data []byte) {
+	gb.joypad.pressed = data[0]
+}
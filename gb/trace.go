@@ -0,0 +1,118 @@
+package gb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/justinawrey/goboy/symbols"
+)
+
+// TraceOptions narrow down what a trace logs
+type TraceOptions struct {
+	// only log instructions with FromPC <= pc <= ToPC.
+	// both 0 logs every pc
+	FromPC, ToPC uint16
+
+	// only log frames FromFrame <= frame < ToFrame.
+	// ToFrame 0 logs until the trace is stopped
+	FromFrame, ToFrame uint64
+
+	// compress the trace
+	Gzip bool
+
+	// if set, each line ends with the symbol pc is in, like "; Main+$3".
+	// gameboy-doctor compares bare lines, so leave it nil for that
+	Symbols *symbols.Table
+}
+
+// tracer writes a line per instruction in gameboy-doctor's format:
+// A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+type tracer struct {
+	opts TraceOptions
+
+	// frames emulated so far, owned by the Gb
+	frame *uint64
+
+	romBank func() int
+
+	out  *bufio.Writer
+	gzip *gzip.Writer
+}
+
+// StartTrace logs every instruction executed from now on to w, before
+// it executes.  a trace that was already running is stopped first
+func (gb *Gb) StartTrace(w io.Writer, opts TraceOptions) (err error) {
+	gb.do(func() {
+		err = gb.stopTrace()
+
+		t := &tracer{opts: opts, frame: &gb.numFrames, romBank: gb.RomBank}
+		if opts.Gzip {
+			t.gzip = gzip.NewWriter(w)
+			w = t.gzip
+		}
+		t.out = bufio.NewWriterSize(w, 64*1024)
+
+		gb.cpu.trace = t
+	})
+	return err
+}
+
+// StopTrace flushes the trace and returns the first error writing it
+func (gb *Gb) StopTrace() (err error) {
+	gb.do(func() { err = gb.stopTrace() })
+	return err
+}
+
+func (gb *Gb) stopTrace() error {
+	t := gb.cpu.trace
+	if t == nil {
+		return nil
+	}
+
+	gb.cpu.trace = nil
+
+	err := t.out.Flush()
+	if t.gzip != nil {
+		if gzErr := t.gzip.Close(); err == nil {
+			err = gzErr
+		}
+	}
+
+	return err
+}
+
+func (t *tracer) wants(pc uint16) bool {
+	o := t.opts
+	frame := *t.frame
+
+	if frame < o.FromFrame || (o.ToFrame > 0 && frame >= o.ToFrame) {
+		return false
+	}
+
+	if o.FromPC == 0 && o.ToPC == 0 {
+		return true
+	}
+
+	return pc >= o.FromPC && pc <= o.ToPC
+}
+
+// called before every instruction while tracing
+func (t *tracer) log(cpu *cpu) {
+	pc := cpu.pc
+	if !t.wants(pc) {
+		return
+	}
+
+	// write errors stick in the bufio.Writer, for StopTrace
+	fmt.Fprintf(t.out,
+		"A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X",
+		cpu.a, cpu.f(), cpu.b, cpu.c, cpu.d, cpu.e, cpu.h, cpu.l, cpu.sp, pc,
+		cpu.fetchByte(pc), cpu.fetchByte(pc+1), cpu.fetchByte(pc+2), cpu.fetchByte(pc+3))
+
+	if syms := t.opts.Symbols; syms != nil {
+		fmt.Fprintf(t.out, " ; %s", syms.Describe(symbols.BankAt(pc, t.romBank()), pc))
+	}
+	t.out.WriteByte('\n')
+}
@@ -0,0 +1,168 @@
+package gb
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/justinawrey/goboy/symbols"
+)
+
+// UnimplementedPolicy is what happens when emulation reaches an
+// instruction goboy doesn't implement yet, which otherwise does nothing
+type UnimplementedPolicy int
+
+const (
+	// run it anyway
+	UnimplementedIgnore UnimplementedPolicy = iota
+
+	// log the first time each one is reached, then run it
+	UnimplementedLog
+
+	// pause before it with a StopUnimplemented, like a breakpoint.
+	// resuming runs it
+	UnimplementedPause
+
+	// stop Run, which returns an UnimplementedError
+	UnimplementedFail
+)
+
+// UnimplementedError is an instruction goboy doesn't implement yet
+type UnimplementedError struct {
+	Mnemonic string
+
+	// 16 bits for the prefixed instructions
+	Opcode uint16
+
+	Bank int
+	PC   uint16
+}
+
+func (e *UnimplementedError) Error() string {
+	return fmt.Sprintf("gb: unimplemented instruction %s ($%02x) at %02x:%04x", e.Mnemonic, e.Opcode, e.Bank, e.PC)
+}
+
+// IllegalOpcodeError is an opcode the cpu doesn't have.  on hardware it
+// locks the cpu up until the power is cycled, and goboy does the same
+type IllegalOpcodeError struct {
+	Opcode byte
+	Bank   int
+	PC     uint16
+}
+
+func (e *IllegalOpcodeError) Error() string {
+	return fmt.Sprintf("gb: illegal opcode $%02x at %02x:%04x locked up the cpu", e.Opcode, e.Bank, e.PC)
+}
+
+// the opcodes the cpu doesn't have
+var illegalOpcodes = [256]bool{
+	0xd3: true, 0xdb: true, 0xdd: true, 0xe3: true, 0xe4: true, 0xeb: true,
+	0xec: true, 0xed: true, 0xf4: true, 0xfc: true, 0xfd: true,
+}
+
+// whether each opcode is implemented, without the prefixed ones, and of
+// the cb prefixed ones by their second byte.  looked up before every
+// instruction, so they're arrays instead of the tables' maps
+var implemented8, implementedCB = implementedTables()
+
+func implementedTables() (ops, cb [256]bool) {
+	for op, in := range InstructionTable8 {
+		ops[op] = in.Implemented
+	}
+	for op, in := range InstructionTable16 {
+		if op>>8 == 0xcb {
+			cb[byte(op)] = in.Implemented
+		}
+	}
+
+	// stop is the only 0x10 prefixed instruction, and is looked up with
+	// its second byte
+	ops[0x10] = InstructionTable16[0x1000].Implemented
+	return ops, cb
+}
+
+// SetUnimplementedPolicy picks what happens at an unimplemented
+// instruction.  it's UnimplementedLog to begin with
+func (gb *Gb) SetUnimplementedPolicy(p UnimplementedPolicy) {
+	gb.do(func() { gb.unimplemented = p })
+}
+
+// applies the policy to the instruction about to execute, and reports
+// whether emulation stops before it
+func (gb *Gb) checkImplemented() bool {
+	cpu := gb.cpu
+	d := gb.debug
+	skip := d.skipUnimplemented
+	d.skipUnimplemented = false
+
+	op := cpu.fetchByte(cpu.pc)
+	opcode := uint16(op)
+	ok := implemented8[op]
+
+	switch op {
+	case 0xcb:
+		second := cpu.fetchByte(cpu.pc + 1)
+		opcode, ok = makeWord(op, second), implementedCB[second]
+	case 0x10:
+		// stop is 10 00, and the tables have nothing for the rest
+		second := cpu.fetchByte(cpu.pc + 1)
+		opcode, ok = makeWord(op, second), ok && second == 0x00
+	}
+
+	// illegal opcodes lock the cpu up instead
+	if ok || illegalOpcodes[op] {
+		return false
+	}
+
+	mnemonic := cpu.decode().Mnemonic
+	if mnemonic == "" {
+		mnemonic = fmt.Sprintf("STOP $%02x", byte(opcode))
+	}
+
+	err := &UnimplementedError{
+		Mnemonic: mnemonic,
+		Opcode:   opcode,
+		Bank:     symbols.BankAt(cpu.pc, gb.RomBank()),
+		PC:       cpu.pc,
+	}
+
+	switch gb.unimplemented {
+	case UnimplementedLog:
+		if !gb.logged[opcode] {
+			gb.logged[opcode] = true
+			log.Print(err)
+		}
+	case UnimplementedPause:
+		if !skip {
+			gb.stop(Stop{Reason: StopUnimplemented, Unimplemented: err})
+			return true
+		}
+	case UnimplementedFail:
+		gb.fault = err
+		return true
+	}
+
+	return false
+}
+
+// called after an instruction locked the cpu up, reporting whether
+// emulation stops for it
+func (gb *Gb) lockedUp() bool {
+	err := gb.cpu.lockup
+	gb.cpu.lockup = nil
+	err.Bank = symbols.BankAt(err.PC, gb.RomBank())
+
+	if gb.crashDir != "" {
+		gb.fault = gb.crash(strings.TrimPrefix(err.Error(), "gb: "), nil)
+		return true
+	}
+
+	log.Print(err)
+	return false
+}
+
+// the illegal opcode at pc hangs the cpu
+func (cpu *cpu) lockUp(opcode byte) {
+	cpu.locked = true
+	cpu.lockup = &IllegalOpcodeError{Opcode: opcode, PC: cpu.pc}
+}
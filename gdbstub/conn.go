@@ -0,0 +1,149 @@
+package gdbstub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// sent by the debugger to interrupt a running target
+const interruptByte = 0x03
+
+var errChecksum = errors.New("gdbstub: bad packet checksum")
+
+// conn frames packets as $data#checksum and acks them
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+
+	// packets and interrupts from the debugger, read ahead so an
+	// interrupt can arrive while the target runs
+	packets    chan string
+	interrupts chan struct{}
+	readErr    error
+}
+
+func newConn(c net.Conn) *conn {
+	cn := &conn{
+		Conn:       c,
+		r:          bufio.NewReader(c),
+		packets:    make(chan string),
+		interrupts: make(chan struct{}, 1),
+	}
+
+	go cn.readLoop()
+	return cn
+}
+
+func (c *conn) readLoop() {
+	defer close(c.packets)
+
+	for {
+		packet, err := c.readPacket()
+		if err != nil {
+			c.readErr = err
+			return
+		}
+
+		c.packets <- packet
+	}
+}
+
+// skips acks, and turns ctrl-c into an interrupt
+func (c *conn) readPacket() (string, error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		switch b {
+		case '$':
+			packet, err := c.readBody()
+			if errors.Is(err, errChecksum) {
+				c.Write([]byte{'-'})
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+
+			c.Write([]byte{'+'})
+			return packet, nil
+		case interruptByte:
+			select {
+			case c.interrupts <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (c *conn) readBody() (string, error) {
+	data, err := c.r.ReadBytes('#')
+	if err != nil {
+		return "", err
+	}
+	data = data[:len(data)-1]
+
+	var sum [2]byte
+	if _, err := io.ReadFull(c.r, sum[:]); err != nil {
+		return "", err
+	}
+
+	// either case of hex
+	want, err := strconv.ParseUint(string(sum[:]), 16, 8)
+	if err != nil || byte(want) != checksum(data) {
+		return "", errChecksum
+	}
+
+	return string(unescape(data)), nil
+}
+
+// sends a packet.  acks from the debugger are skipped by readPacket
+func (c *conn) send(data string) error {
+	escaped := escape([]byte(data))
+	_, err := fmt.Fprintf(c, "$%s#%02x", escaped, checksum(escaped))
+	return err
+}
+
+func checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+
+	return sum
+}
+
+// '}' escapes the next byte xor 0x20, used for binary data
+func unescape(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^0x20)
+			continue
+		}
+		out = append(out, data[i])
+	}
+
+	return out
+}
+
+func escape(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for _, b := range data {
+		switch b {
+		case '$', '#', '}', '*':
+			out = append(out, '}', b^0x20)
+		default:
+			out = append(out, b)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,256 @@
+package gdbstub
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/justinawrey/goboy/expr"
+	"github.com/justinawrey/goboy/gb"
+)
+
+// tracepoint messages waiting for gdb to be told, extras are dropped
+const maxLogs = 256
+
+const monitorHelp = `break <addr> [if <expr>]   break at addr, when expr holds
+break if <expr>            break before any instruction where expr holds
+trace <addr> <message>     log message, with {expr}s filled in, at addr
+ignore <id> <n>            let breakpoint id pass its next n hits
+delete <id>                remove a breakpoint set here
+info                       list breakpoints set here
+print <expr>               evaluate an expression
+last-write <addr>          find the last write to addr in the rewind history
+`
+
+// qRcmd carries gdb's "monitor" commands, for what the protocol can't
+// express, like breakpoints with conditions evaluated in the emulator
+func (s *Server) monitor(c *conn, hexCmd string) string {
+	cmd, err := hex.DecodeString(hexCmd)
+	if err != nil {
+		return "E01"
+	}
+
+	out, err := s.runMonitor(string(cmd))
+	if err != nil {
+		out = err.Error() + "\n"
+	}
+
+	if out != "" {
+		c.send("O" + hex.EncodeToString([]byte(out)))
+	}
+	return "OK"
+}
+
+func (s *Server) runMonitor(line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return monitorHelp, nil
+	}
+
+	args := fields[1:]
+	switch fields[0] {
+	case "break":
+		return s.monitorBreak(args)
+	case "trace":
+		return s.monitorTrace(args)
+	case "ignore":
+		return s.monitorIgnore(args)
+	case "delete":
+		return s.monitorDelete(args)
+	case "info":
+		return s.monitorInfo(), nil
+	case "print":
+		e, err := s.compile(strings.Join(args, " "))
+		if err != nil {
+			return "", err
+		}
+		n := s.gb.Eval(e)
+		return fmt.Sprintf("%s (%d)\n", expr.Format(n), n), nil
+	case "last-write":
+		return s.monitorLastWrite(args)
+	case "help":
+		return monitorHelp, nil
+	}
+
+	return "", fmt.Errorf("unknown command %q, try monitor help", fields[0])
+}
+
+func (s *Server) compile(src string) (*expr.Expr, error) {
+	return expr.Compile(src, s.syms.Addr)
+}
+
+func (s *Server) monitorBreak(args []string) (string, error) {
+	bp := gb.Breakpoint{Kind: gb.BreakPC}
+
+	ifAt := len(args)
+	for i, arg := range args {
+		if arg == "if" {
+			ifAt = i
+			break
+		}
+	}
+
+	if ifAt < len(args) {
+		e, err := s.compile(strings.Join(args[ifAt+1:], " "))
+		if err != nil {
+			return "", err
+		}
+		bp.Expr = e
+	}
+
+	switch ifAt {
+	case 0:
+		if bp.Expr == nil {
+			return "", errors.New("usage: break <addr> [if <expr>]")
+		}
+		bp.Kind = gb.BreakExpr
+	case 1:
+		addr, err := s.resolve(args[0])
+		if err != nil {
+			return "", err
+		}
+		bp.Addr = addr
+	default:
+		return "", errors.New("usage: break <addr> [if <expr>]")
+	}
+
+	id := s.gb.AddBreakpoint(bp)
+	s.monitorBps[id] = true
+	return fmt.Sprintf("breakpoint %d\n", id), nil
+}
+
+func (s *Server) monitorTrace(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", errors.New("usage: trace <addr> <message>")
+	}
+
+	addr, err := s.resolve(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	msg, err := expr.CompileTemplate(strings.Join(args[1:], " "), s.syms.Addr)
+	if err != nil {
+		return "", err
+	}
+
+	id := s.gb.AddBreakpoint(gb.Breakpoint{Kind: gb.BreakPC, Addr: addr, Message: msg, Log: s.log})
+	s.monitorBps[id] = true
+	return fmt.Sprintf("tracepoint %d\n", id), nil
+}
+
+// runs on the emulation goroutine, so it mustn't block
+func (s *Server) log(id int, msg string) {
+	select {
+	case s.logs <- fmt.Sprintf("[%d] %s\n", id, msg):
+	default:
+	}
+}
+
+func (s *Server) monitorIgnore(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", errors.New("usage: ignore <id> <n>")
+	}
+
+	id, err1 := strconv.Atoi(args[0])
+	n, err2 := strconv.Atoi(args[1])
+	if err1 != nil || err2 != nil || n < 0 {
+		return "", errors.New("usage: ignore <id> <n>")
+	}
+
+	bp, ok := s.gb.Breakpoints()[id]
+	if !ok || !s.monitorBps[id] {
+		return "", fmt.Errorf("no breakpoint %d", id)
+	}
+
+	bp.Ignore = bp.Hits + n
+	s.gb.UpdateBreakpoint(id, bp)
+	return "", nil
+}
+
+func (s *Server) monitorDelete(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("usage: delete <id>")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil || !s.monitorBps[id] {
+		return "", fmt.Errorf("no breakpoint %s", args[0])
+	}
+
+	s.gb.RemoveBreakpoint(id)
+	delete(s.monitorBps, id)
+	return "", nil
+}
+
+func (s *Server) monitorInfo() string {
+	bps := s.gb.Breakpoints()
+
+	var ids []int
+	for id := range s.monitorBps {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var b strings.Builder
+	for _, id := range ids {
+		bp := bps[id]
+
+		fmt.Fprintf(&b, "%3d  ", id)
+		if bp.Kind == gb.BreakExpr {
+			b.WriteString("any pc")
+		} else {
+			fmt.Fprintf(&b, "pc %04x", bp.Addr)
+		}
+		if bp.Expr != nil {
+			fmt.Fprintf(&b, " if %s", bp.Expr)
+		}
+		if bp.Message != nil {
+			fmt.Fprintf(&b, " trace %q", bp.Message)
+		}
+		fmt.Fprintf(&b, ", hit %d times\n", bp.Hits)
+	}
+
+	if b.Len() == 0 {
+		return "no breakpoints\n"
+	}
+	return b.String()
+}
+
+func (s *Server) monitorLastWrite(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("usage: last-write <addr>")
+	}
+
+	addr, err := s.resolve(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	w, ok, err := s.gb.LastWrite(addr)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return fmt.Sprintf("no writes to %04x in the history\n", addr), nil
+	}
+
+	ago := s.gb.Instructions() - w.Instructions
+	return fmt.Sprintf("wrote %02x -> %02x at pc %04x, frame %d, %d instructions ago\n", w.Old, w.New, w.PC, w.Frame, ago), nil
+}
+
+// addresses are hex, or symbols
+func (s *Server) resolve(name string) (uint16, error) {
+	sym, err := s.syms.Resolve(name)
+	return sym.Addr, err
+}
+
+func (s *Server) removeMonitorBreakpoints() {
+	for id := range s.monitorBps {
+		s.gb.RemoveBreakpoint(id)
+	}
+	s.monitorBps = make(map[int]bool)
+}
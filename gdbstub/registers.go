@@ -0,0 +1,113 @@
+package gdbstub
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/justinawrey/goboy/gb"
+)
+
+// gdb numbers registers in the order target.xml lists them
+const (
+	regSP  = 8
+	regPC  = 9
+	numReg = 10
+
+	// 8 one byte registers, then sp and pc
+	regsSize = 12
+)
+
+const targetXml = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.goboy.sm83">
+    <flags id="sm83_flags" size="1">
+      <field name="c" start="4" end="4"/>
+      <field name="h" start="5" end="5"/>
+      <field name="n" start="6" end="6"/>
+      <field name="z" start="7" end="7"/>
+    </flags>
+    <reg name="a" bitsize="8" type="uint8" regnum="0"/>
+    <reg name="f" bitsize="8" type="sm83_flags"/>
+    <reg name="b" bitsize="8" type="uint8"/>
+    <reg name="c" bitsize="8" type="uint8"/>
+    <reg name="d" bitsize="8" type="uint8"/>
+    <reg name="e" bitsize="8" type="uint8"/>
+    <reg name="h" bitsize="8" type="uint8"/>
+    <reg name="l" bitsize="8" type="uint8"/>
+    <reg name="sp" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>`
+
+// the registers as gdb lays them out, 16-bit ones little endian
+func encodeRegisters(r gb.Registers) []byte {
+	buf := []byte{r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(buf[8:], r.SP)
+	binary.LittleEndian.PutUint16(buf[10:], r.PC)
+	return buf
+}
+
+func decodeRegisters(buf []byte) gb.Registers {
+	return gb.Registers{
+		A: buf[0], F: buf[1], B: buf[2], C: buf[3],
+		D: buf[4], E: buf[5], H: buf[6], L: buf[7],
+		SP: binary.LittleEndian.Uint16(buf[8:]),
+		PC: binary.LittleEndian.Uint16(buf[10:]),
+	}
+}
+
+// where register n is in the layout, and how big it is
+func registerSlot(n int) (offset, size int) {
+	if n < regSP {
+		return n, 1
+	}
+
+	return regSP + (n-regSP)*2, 2
+}
+
+func (s *Server) readRegisters() string {
+	return hex.EncodeToString(encodeRegisters(s.gb.Registers()))
+}
+
+func (s *Server) writeRegisters(args string) string {
+	buf, err := hex.DecodeString(args)
+	if err != nil || len(buf) != regsSize {
+		return "E01"
+	}
+
+	s.gb.SetRegisters(decodeRegisters(buf))
+	return "OK"
+}
+
+func (s *Server) readRegister(args string) string {
+	n, err := strconv.ParseUint(args, 16, 8)
+	if err != nil || n >= numReg {
+		return "E01"
+	}
+
+	offset, size := registerSlot(int(n))
+	buf := encodeRegisters(s.gb.Registers())
+	return hex.EncodeToString(buf[offset : offset+size])
+}
+
+func (s *Server) writeRegister(args string) string {
+	nStr, valueStr, _ := strings.Cut(args, "=")
+	n, err := strconv.ParseUint(nStr, 16, 8)
+	if err != nil || n >= numReg {
+		return "E01"
+	}
+
+	value, err := hex.DecodeString(valueStr)
+	offset, size := registerSlot(int(n))
+	if err != nil || len(value) != size {
+		return "E01"
+	}
+
+	buf := encodeRegisters(s.gb.Registers())
+	copy(buf[offset:], value)
+	s.gb.SetRegisters(decodeRegisters(buf))
+	return "OK"
+}
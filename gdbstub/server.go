@@ -0,0 +1,484 @@
+package gdbstub
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/justinawrey/goboy/gb"
+	"github.com/justinawrey/goboy/symbols"
+)
+
+// gdb's signal numbers, for stop replies
+const (
+	sigint  = 2
+	sigill  = 4
+	sigtrap = 5
+)
+
+// sent instead of a reply when the debugger goes away
+var errDetach = errors.New("gdbstub: detached")
+
+// gdb's breakpoint types, from Z packets
+const (
+	zSoftware = '0'
+	zHardware = '1'
+	zWrite    = '2'
+	zRead     = '3'
+	zAccess   = '4'
+)
+
+// a breakpoint as gdb sees it
+type breakpoint struct {
+	kind   byte
+	addr   uint16
+	length int
+}
+
+// Server lets gdb and other remote serial protocol clients debug a Gb,
+// one at a time.  everything goes through the Gb's control api, so it
+// can debug a Gb that is running in a window
+type Server struct {
+	gb   *gb.Gb
+	syms *symbols.Table
+
+	// the Gb breakpoint set for each of gdb's
+	breakpoints map[breakpoint]int
+
+	// which of gdb's breakpoints each Gb breakpoint belongs to
+	owners map[int]breakpoint
+
+	// breakpoints and tracepoints set with monitor commands
+	monitorBps map[int]bool
+	logs       chan string
+}
+
+// NewServer debugs gameboy.  syms, which may be nil, lets monitor
+// commands name addresses
+func NewServer(gameboy *gb.Gb, syms *symbols.Table) *Server {
+	return &Server{
+		gb:          gameboy,
+		syms:        syms,
+		breakpoints: make(map[breakpoint]int),
+		owners:      make(map[int]breakpoint),
+		monitorBps:  make(map[int]bool),
+		logs:        make(chan string, maxLogs),
+	}
+}
+
+// ListenAndServe serves debuggers on a tcp address, like localhost:2345
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	return s.Serve(l)
+}
+
+// Serve serves each connection from l in turn
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		if err := s.serveConn(c); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// the target stops when a debugger attaches, and runs on
+// without breakpoints once it goes
+func (s *Server) serveConn(netConn net.Conn) error {
+	c := newConn(netConn)
+	defer c.Close()
+	defer s.detach()
+
+	s.gb.Pause()
+
+	for packet := range c.packets {
+		reply, err := s.handle(c, packet)
+		if errors.Is(err, errDetach) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := c.send(reply); err != nil {
+			return err
+		}
+	}
+
+	return c.readErr
+}
+
+func (s *Server) detach() {
+	for bp := range s.breakpoints {
+		s.removeBreakpoint(bp)
+	}
+	s.removeMonitorBreakpoints()
+
+	s.gb.Resume()
+}
+
+// returns the reply to packet
+func (s *Server) handle(c *conn, packet string) (string, error) {
+	if packet == "" {
+		return "", nil
+	}
+
+	cmd, args := packet[0], packet[1:]
+
+	switch cmd {
+	case '?':
+		return fmt.Sprintf("S%02x", sigtrap), nil
+	case 'g':
+		return s.readRegisters(), nil
+	case 'G':
+		return s.writeRegisters(args), nil
+	case 'p':
+		return s.readRegister(args), nil
+	case 'P':
+		return s.writeRegister(args), nil
+	case 'm':
+		return s.readMemory(args), nil
+	case 'M':
+		return s.writeMemory(args, false), nil
+	case 'X':
+		return s.writeMemory(args, true), nil
+	case 'c':
+		if !s.jump(args) {
+			return "E01", nil
+		}
+		return s.cont(c)
+	case 's':
+		if !s.jump(args) {
+			return "E01", nil
+		}
+		s.gb.Step()
+		return fmt.Sprintf("S%02x", sigtrap), nil
+	case 'b':
+		return s.reverse(args), nil
+	case 'Z':
+		return s.setBreakpoint(args), nil
+	case 'z':
+		return s.clearBreakpoint(args), nil
+	case 'q':
+		if strings.HasPrefix(args, "Rcmd,") {
+			return s.monitor(c, strings.TrimPrefix(args, "Rcmd,")), nil
+		}
+		return s.query(args), nil
+	case 'H', 'T':
+		return "OK", nil
+	case 'D':
+		c.send("OK")
+		return "", errDetach
+	case 'k':
+		return "", errDetach
+	case 'v':
+		if args == "Kill" || strings.HasPrefix(args, "Kill;") {
+			c.send("OK")
+			return "", errDetach
+		}
+	}
+
+	// an empty reply means unsupported
+	return "", nil
+}
+
+func (s *Server) query(args string) string {
+	switch {
+	case strings.HasPrefix(args, "Supported"):
+		return "PacketSize=4000;qXfer:features:read+;swbreak+;hwbreak+;ReverseStep+;ReverseContinue+"
+	case args == "Attached":
+		return "1"
+	case args == "C":
+		return "QC1"
+	case args == "fThreadInfo":
+		return "m1"
+	case args == "sThreadInfo":
+		return "l"
+	case strings.HasPrefix(args, "Xfer:features:read:target.xml:"):
+		return s.targetXml(strings.TrimPrefix(args, "Xfer:features:read:target.xml:"))
+	}
+
+	return ""
+}
+
+// sends the target description a chunk at a time, off,length
+func (s *Server) targetXml(args string) string {
+	offStr, lengthStr, _ := strings.Cut(args, ",")
+	off, err1 := strconv.ParseUint(offStr, 16, 32)
+	length, err2 := strconv.ParseUint(lengthStr, 16, 32)
+	if err1 != nil || err2 != nil {
+		return "E01"
+	}
+
+	if off >= uint64(len(targetXml)) {
+		return "l"
+	}
+
+	end := off + length
+	if end >= uint64(len(targetXml)) {
+		return "l" + targetXml[off:]
+	}
+
+	return "m" + targetXml[off:end]
+}
+
+// continue and step can say where to resume from
+func (s *Server) jump(args string) bool {
+	if args == "" {
+		return true
+	}
+
+	addr, err := strconv.ParseUint(args, 16, 16)
+	if err != nil {
+		return false
+	}
+
+	regs := s.gb.Registers()
+	regs.PC = uint16(addr)
+	s.gb.SetRegisters(regs)
+	return true
+}
+
+// resumes and waits for a breakpoint or an interrupt from the debugger
+func (s *Server) cont(c *conn) (string, error) {
+	select {
+	case <-c.interrupts:
+	default:
+	}
+
+	s.gb.Resume()
+
+	for {
+		select {
+		case stop := <-s.gb.Stops():
+			return s.stopReply(stop), s.flushLogs(c)
+		case <-c.interrupts:
+			s.gb.Pause()
+			return fmt.Sprintf("S%02x", sigint), s.flushLogs(c)
+		case msg := <-s.logs:
+			if err := s.sendLog(c, msg); err != nil {
+				return "", err
+			}
+		case _, ok := <-c.packets:
+			// nothing but an interrupt should arrive while running
+			if !ok {
+				s.gb.Pause()
+				return "", errDetach
+			}
+		}
+	}
+}
+
+// bs and bc step and continue backwards through the rewind history
+func (s *Server) reverse(args string) string {
+	historyStart := fmt.Sprintf("T%02xreplaylog:begin;", sigtrap)
+
+	switch args {
+	case "s":
+		err := s.gb.ReverseStep()
+		if errors.Is(err, gb.ErrHistoryStart) {
+			return historyStart
+		}
+		if err != nil {
+			return "E01"
+		}
+		return fmt.Sprintf("S%02x", sigtrap)
+	case "c":
+		if err := s.gb.ReverseContinue(); err != nil {
+			return "E01"
+		}
+
+		select {
+		case stop := <-s.gb.Stops():
+			if stop.Reason == gb.StopHistoryStart {
+				return historyStart
+			}
+			return s.stopReply(stop)
+		default:
+			return fmt.Sprintf("S%02x", sigtrap)
+		}
+	}
+
+	return ""
+}
+
+// console output is allowed while the target runs
+func (s *Server) sendLog(c *conn, msg string) error {
+	return c.send("O" + hex.EncodeToString([]byte(msg)))
+}
+
+// sends the tracepoints logged before a stop
+func (s *Server) flushLogs(c *conn) error {
+	for {
+		select {
+		case msg := <-s.logs:
+			if err := s.sendLog(c, msg); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (s *Server) stopReply(stop gb.Stop) string {
+	if stop.Reason == gb.StopUnimplemented {
+		return fmt.Sprintf("S%02x", sigill)
+	}
+
+	bp, ok := s.owners[stop.Breakpoint]
+	if stop.Reason != gb.StopBreakpoint || !ok {
+		return fmt.Sprintf("S%02x", sigtrap)
+	}
+
+	// the address that was accessed, somewhere in the watched range
+	addr := bp.addr
+	if stop.Access != nil {
+		addr = stop.Access.Addr
+	}
+
+	switch bp.kind {
+	case zSoftware:
+		return fmt.Sprintf("T%02xswbreak:;", sigtrap)
+	case zHardware:
+		return fmt.Sprintf("T%02xhwbreak:;", sigtrap)
+	case zWrite:
+		return fmt.Sprintf("T%02xwatch:%x;", sigtrap, addr)
+	case zRead:
+		return fmt.Sprintf("T%02xrwatch:%x;", sigtrap, addr)
+	default:
+		return fmt.Sprintf("T%02xawatch:%x;", sigtrap, addr)
+	}
+}
+
+// Z and z take type,addr,kind.  for watchpoints kind is the length
+func parseBreakpoint(args string) (breakpoint, bool) {
+	parts := strings.Split(args, ",")
+	if len(parts) < 3 || len(parts[0]) != 1 || parts[0][0] < zSoftware || parts[0][0] > zAccess {
+		return breakpoint{}, false
+	}
+
+	addr, err1 := strconv.ParseUint(parts[1], 16, 16)
+	length, err2 := strconv.ParseUint(parts[2], 16, 16)
+	if err1 != nil || err2 != nil {
+		return breakpoint{}, false
+	}
+
+	bp := breakpoint{kind: parts[0][0], addr: uint16(addr), length: int(length)}
+	if bp.kind == zSoftware || bp.kind == zHardware || bp.length < 1 {
+		bp.length = 1
+	}
+
+	return bp, true
+}
+
+func (s *Server) setBreakpoint(args string) string {
+	bp, ok := parseBreakpoint(args)
+	if !ok {
+		return "E01"
+	}
+
+	if _, exists := s.breakpoints[bp]; exists {
+		return "OK"
+	}
+
+	var kind gb.BreakKind
+	switch bp.kind {
+	case zSoftware, zHardware:
+		kind = gb.BreakPC
+	case zWrite:
+		kind = gb.BreakWrite
+	case zRead:
+		kind = gb.BreakRead
+	case zAccess:
+		kind = gb.BreakAccess
+	}
+
+	id := s.gb.AddBreakpoint(gb.Breakpoint{Kind: kind, Addr: bp.addr, End: bp.addr + uint16(bp.length-1)})
+	s.owners[id] = bp
+	s.breakpoints[bp] = id
+	return "OK"
+}
+
+func (s *Server) clearBreakpoint(args string) string {
+	bp, ok := parseBreakpoint(args)
+	if !ok {
+		return "E01"
+	}
+
+	s.removeBreakpoint(bp)
+	return "OK"
+}
+
+func (s *Server) removeBreakpoint(bp breakpoint) {
+	id, ok := s.breakpoints[bp]
+	if !ok {
+		return
+	}
+
+	s.gb.RemoveBreakpoint(id)
+	delete(s.owners, id)
+	delete(s.breakpoints, bp)
+}
+
+// m and M take addr,length
+func parseRange(args string) (addr uint16, length int, ok bool) {
+	addrStr, lengthStr, found := strings.Cut(args, ",")
+	if !found {
+		return 0, 0, false
+	}
+
+	a, err1 := strconv.ParseUint(addrStr, 16, 16)
+	l, err2 := strconv.ParseUint(lengthStr, 16, 32)
+	if err1 != nil || err2 != nil || l > 0x10000 {
+		return 0, 0, false
+	}
+
+	return uint16(a), int(l), true
+}
+
+func (s *Server) readMemory(args string) string {
+	addr, length, ok := parseRange(args)
+	if !ok {
+		return "E01"
+	}
+
+	return hex.EncodeToString(s.gb.ReadMemory(addr, length))
+}
+
+// M sends hex, X sends escaped binary
+func (s *Server) writeMemory(args string, binary bool) string {
+	rangeStr, dataStr, found := strings.Cut(args, ":")
+	addr, length, ok := parseRange(rangeStr)
+	if !found || !ok {
+		return "E01"
+	}
+
+	data := []byte(dataStr)
+	if !binary {
+		var err error
+		if data, err = hex.DecodeString(dataStr); err != nil {
+			return "E01"
+		}
+	}
+
+	if len(data) != length {
+		return "E01"
+	}
+
+	s.gb.WriteMemory(addr, data)
+	return "OK"
+}
@@ -0,0 +1,191 @@
+package gdbstub
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/justinawrey/goboy/gb"
+)
+
+// ld hl,$c000; ld a,$05, then a loop at $0105 storing and bumping a
+var testCode = []byte{0x21, 0x00, 0xc0, 0x3e, 0x05, 0x77, 0x3c, 0xc3, 0x05, 0x01}
+
+const loopAddr = 0x0105
+
+func testGb(t *testing.T) *gb.Gb {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x100:], testCode)
+
+	path := filepath.Join(t.TempDir(), "test.gb")
+	if err := os.WriteFile(path, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gameboy := gb.NewGb()
+	gameboy.LoadCartridge(path)
+	gameboy.SetSpeed(0)
+	gameboy.Pause()
+	gameboy.Reset(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- gameboy.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return gameboy
+}
+
+// a scripted debugger on the other end of a pipe
+type client struct {
+	t *testing.T
+	net.Conn
+	r *bufio.Reader
+}
+
+func connect(t *testing.T, gameboy *gb.Gb) *client {
+	ours, theirs := net.Pipe()
+
+	s := NewServer(gameboy, nil)
+	served := make(chan error, 1)
+	go func() { served <- s.serveConn(theirs) }()
+	t.Cleanup(func() {
+		ours.Close()
+		<-served
+	})
+
+	return &client{t: t, Conn: ours, r: bufio.NewReader(ours)}
+}
+
+func (c *client) write(s string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c, s); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) readByte() byte {
+	c.t.Helper()
+	b, err := c.r.ReadByte()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return b
+}
+
+// sends packet, checks it's acked, and returns the reply after checking
+// its checksum and acking it
+func (c *client) request(packet string) string {
+	c.t.Helper()
+
+	c.write(fmt.Sprintf("$%s#%02x", packet, checksum([]byte(packet))))
+	if ack := c.readByte(); ack != '+' {
+		c.t.Fatalf("%s: ack %q, want '+'", packet, ack)
+	}
+
+	if b := c.readByte(); b != '$' {
+		c.t.Fatalf("%s: reply starts with %q, want '$'", packet, b)
+	}
+	body, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	body = strings.TrimSuffix(body, "#")
+
+	sum := string([]byte{c.readByte(), c.readByte()})
+	if want := fmt.Sprintf("%02x", checksum([]byte(body))); sum != want {
+		c.t.Fatalf("%s: reply checksum %s, want %s", packet, sum, want)
+	}
+
+	c.write("+")
+	return string(unescape([]byte(body)))
+}
+
+func (c *client) expect(packet, want string) {
+	c.t.Helper()
+	if got := c.request(packet); got != want {
+		c.t.Fatalf("%s: got %q, want %q", packet, got, want)
+	}
+}
+
+// the pc from a g reply
+func (c *client) pc() uint16 {
+	c.t.Helper()
+	regs := c.request("g")
+	if len(regs) != 2*regsSize {
+		c.t.Fatalf("g: got %q, want %d hex digits", regs, 2*regsSize)
+	}
+
+	var lo, hi uint16
+	fmt.Sscanf(regs[20:22], "%02x", &lo)
+	fmt.Sscanf(regs[22:24], "%02x", &hi)
+	return hi<<8 | lo
+}
+
+func TestServer(t *testing.T) {
+	c := connect(t, testGb(t))
+
+	if got := c.request("qSupported:swbreak+;hwbreak+"); !strings.Contains(got, "swbreak+") {
+		t.Fatalf("qSupported: got %q, want swbreak+", got)
+	}
+	c.expect("?", "S05")
+
+	if pc := c.pc(); pc != 0x0100 {
+		t.Fatalf("pc %04x, want 0100", pc)
+	}
+
+	c.expect("m0100,3", "2100c0")
+	c.expect("Md000,2:abcd", "OK")
+	c.expect("md000,2", "abcd")
+
+	c.expect(fmt.Sprintf("Z0,%x,1", loopAddr), "OK")
+	c.expect("c", "T05swbreak:;")
+	if pc := c.pc(); pc != loopAddr {
+		t.Fatalf("stopped at %04x, want %04x", pc, loopAddr)
+	}
+
+	// stopped before ld (hl),a, then stepping runs it
+	c.expect("mc000,1", "00")
+	c.expect("s", "S05")
+	if pc := c.pc(); pc != loopAddr+1 {
+		t.Fatalf("stepped to %04x, want %04x", pc, loopAddr+1)
+	}
+	c.expect("mc000,1", "05")
+
+	c.expect(fmt.Sprintf("z0,%x,1", loopAddr), "OK")
+}
+
+func TestBadChecksum(t *testing.T) {
+	c := connect(t, testGb(t))
+
+	c.write("$g#00")
+	if nak := c.readByte(); nak != '-' {
+		t.Fatalf("got %q, want '-'", nak)
+	}
+
+	// the debugger retransmits, and that's fine
+	c.expect("?", "S05")
+}
+
+func TestUpperCaseChecksum(t *testing.T) {
+	c := connect(t, testGb(t))
+
+	c.write("$qC#B4")
+	if ack := c.readByte(); ack != '+' {
+		t.Fatalf("got %q, want '+'", ack)
+	}
+
+	reply, err := c.r.ReadString('#')
+	if err != nil || reply != "$QC1#" {
+		t.Fatalf("got %q, %v, want $QC1#", reply, err)
+	}
+}
@@ -1,44 +1,608 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/justinawrey/goboy/app"
 	"github.com/justinawrey/goboy/audit"
+	"github.com/justinawrey/goboy/coverage"
+	"github.com/justinawrey/goboy/dap"
+	"github.com/justinawrey/goboy/debugger"
+	"github.com/justinawrey/goboy/disasm"
 	"github.com/justinawrey/goboy/gb"
+	"github.com/justinawrey/goboy/gdbstub"
+	"github.com/justinawrey/goboy/profile"
+	"github.com/justinawrey/goboy/singlestep"
+	"github.com/justinawrey/goboy/symbols"
+	"github.com/justinawrey/goboy/tracediff"
 )
 
-// goboy run -- runs goboy
-// goboy audit -- generates cpu opcode completion chart
+const defaultRom = "./rom/tetris.gb"
+
+// goboy run [--ui gl|term] [--cdl file] [--crash-dir dir] [--unimplemented ignore|log|pause|fail] [rom] -- runs goboy
+// goboy debug [--history seconds] [rom] -- debugs goboy interactively
+// goboy disasm [--bank n] [--from addr] [--to addr] rom -- disassembles a rom bank
+// goboy tracediff [--context n] [--rom rom] a.log b.log -- finds where two cpu traces diverge
+// goboy profile [--frames n] [--top n] [--pprof file] [rom] -- profiles where a rom spends its cycles
+// goboy coverage [--cdl file] [--lcov file] [--html file] rom -- reports the code a code/data log saw run
+// goboy dap [--listen addr] -- serves the debug adapter protocol on stdio or tcp
+// goboy vectors [--all] dir -- checks the cpu against single step test vectors
+// goboy audit [--vectors dir] -- generates cpu opcode completion chart
 func main() {
 	args := os.Args[1:]
 
-	if len(args) != 1 {
+	if len(args) < 1 {
 		fail()
 	}
 
 	switch cmd := args[0]; cmd {
 	case "run":
-		app.Run(run)
+		run(args[1:])
+	case "debug":
+		debug(args[1:])
+	case "disasm":
+		disassemble(args[1:])
+	case "tracediff":
+		traceDiff(args[1:])
+	case "profile":
+		profileRom(args[1:])
+	case "coverage":
+		reportCoverage(args[1:])
+	case "dap":
+		serveDap(args[1:])
+	case "vectors":
+		checkVectors(args[1:])
 	case "audit":
-		audit.Generate()
+		auditCpu(args[1:])
+	default:
+		fail()
+	}
+}
+
+func run(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	ui := flags.String("ui", "gl", "user interface: gl or term")
+	rewind := flags.Float64("rewind", 10, "seconds of rewind history, 0 to disable")
+	granularity := flags.Int("rewind-granularity", 10, "frames between rewind snapshots")
+	frameSkip := flags.Bool("frameskip", true, "skip rendering while fast-forwarding")
+	ramPattern := flags.String("ram-pattern", "zero", "ram contents after a hard reset: zero, random or dmg")
+	sync := flags.String("sync", "timer", "pace emulation with a timer or to the display's vsync")
+	trace := flags.String("trace", "", "log every instruction to a file, gzipped if it ends in .gz")
+	tracePc := flags.String("trace-pc", "", "only trace pcs in a hex range, e.g. 0150-01ff")
+	traceFrames := flags.String("trace-frames", "", "only trace frames in a range, e.g. 60-120")
+	traceLabels := flags.Bool("trace-labels", false, "end trace lines with the symbol pc is in, from the rom's .sym or .map file")
+	gdb := flags.String("gdb", "", "start paused, serving gdb remote debugging on an address like localhost:2345")
+	cdl := flags.String("cdl", "", "mark the rom bytes run and read in a code/data log file, adding to it if it exists")
+	crashDir := flags.String("crash-dir", ".", "directory to write a report to if emulation crashes, empty to panic instead")
+	unimplemented := flags.String("unimplemented", "log", "at unimplemented instructions: ignore, log once, pause for --gdb or fail")
+	flags.Parse(args)
+
+	syncMode, ok := syncModes[*sync]
+	if !ok {
+		fail()
+	}
+
+	pattern, ok := ramPatterns[*ramPattern]
+	if !ok {
+		fail()
+	}
+
+	policy, ok := unimplementedPolicies[*unimplemented]
+	if !ok {
+		fail()
+	}
+
+	traceOpts := gb.TraceOptions{Gzip: strings.HasSuffix(*trace, ".gz")}
+	if *tracePc != "" {
+		from, to, ok := parseRange(*tracePc, 16, 16)
+		if !ok {
+			fail()
+		}
+		traceOpts.FromPC, traceOpts.ToPC = uint16(from), uint16(to)
+	}
+	if *traceFrames != "" {
+		from, to, ok := parseRange(*traceFrames, 10, 64)
+		if !ok {
+			fail()
+		}
+		traceOpts.FromFrame, traceOpts.ToFrame = from, to+1
+	}
+
+	opts := options{
+		rewindSeconds:     *rewind,
+		rewindGranularity: *granularity,
+		frameSkip:         *frameSkip,
+		ramPattern:        pattern,
+		syncMode:          syncMode,
+		trace:             *trace,
+		traceOpts:         traceOpts,
+		gdb:               *gdb,
+		cdl:               *cdl,
+		crashDir:          *crashDir,
+		unimplemented:     policy,
+	}
+
+	rom := defaultRom
+	if flags.NArg() > 0 {
+		rom = flags.Arg(0)
+	}
+
+	if *trace != "" && *traceLabels {
+		syms, err := symbols.ForRom(rom)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.traceOpts.Symbols = syms
+	}
+
+	switch *ui {
+	case "gl":
+		app.Run(func() { runGl(rom, opts) })
+	case "term":
+		runTerm(rom, opts)
 	default:
 		fail()
 	}
 }
 
-func run() {
-	gb := gb.NewGb()
-	display := app.NewDisplay()
+func debug(args []string) {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	history := flags.Float64("history", 10, "seconds of history for reverse-step and reverse-continue, 0 to disable")
+	flags.Parse(args)
+
+	rom := defaultRom
+	if flags.NArg() > 0 {
+		rom = flags.Arg(0)
+	}
+
+	if err := debugger.Run(rom, *history); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func profileRom(args []string) {
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	frames := flags.Uint64("frames", 600, "frames to emulate")
+	top := flags.Int("top", 20, "routines to report, 0 for all")
+	pprof := flags.String("pprof", "", "also write a profile for go tool pprof to a file")
+	flags.Parse(args)
+
+	rom := defaultRom
+	if flags.NArg() > 0 {
+		rom = flags.Arg(0)
+	}
+
+	syms, err := symbols.ForRom(rom)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	gameboy := gb.NewGb()
+	gameboy.LoadCartridge(rom)
+	gameboy.EnableCallStack(true)
+	gameboy.SetSpeed(0)
+	gameboy.Pause()
+
+	// boot now, so the profile doesn't race Run booting it
+	gameboy.Reset(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- gameboy.Run(ctx) }()
+
+	gameboy.StartProfile()
+	gameboy.RunToFrame(*frames)
+
+	select {
+	case <-gameboy.Stops():
+	case err := <-done:
+		log.Fatal(err)
+	}
+
+	p := gameboy.StopProfile()
+	cancel()
+	<-done
+
+	if err := profile.Report(os.Stdout, p, syms, *top); err != nil {
+		log.Fatal(err)
+	}
+
+	if *pprof != "" {
+		writeFile(*pprof, func(w io.Writer) error { return profile.WritePprof(w, p, syms) })
+	}
+}
+
+func reportCoverage(args []string) {
+	flags := flag.NewFlagSet("coverage", flag.ExitOnError)
+	cdlPath := flags.String("cdl", "", "code/data log, defaults to the .cdl next to the rom")
+	lcov := flags.String("lcov", "", "write an lcov tracefile, and the disassembly it covers next to it as .asm")
+	html := flags.String("html", "", "write an html report")
+
+	// flags may come before or after the rom
+	var rom string
+	for flags.Parse(args); flags.NArg() > 0; flags.Parse(args) {
+		rom, args = flags.Arg(0), flags.Args()[1:]
+	}
+
+	if rom == "" {
+		fail()
+	}
+	if *cdlPath == "" {
+		*cdlPath = coverage.ForRom(rom)
+	}
+
+	data, err := os.ReadFile(rom)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	syms, err := symbols.ForRom(rom)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cdl, err := coverage.Load(*cdlPath, len(data))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := coverage.New(data, cdl, syms)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%d of %d instructions ran, %.1f%%\n", report.Hit, report.Counted, coverage.Percent(report.Hit, report.Counted))
+
+	if *lcov != "" {
+		listing := strings.TrimSuffix(*lcov, filepath.Ext(*lcov)) + ".asm"
+		writeFile(listing, report.WriteListing)
+		writeFile(*lcov, func(w io.Writer) error { return report.WriteLcov(w, listing) })
+	}
+
+	if *html != "" {
+		writeFile(*html, func(w io.Writer) error { return report.WriteHTML(w, filepath.Base(rom)) })
+	}
+}
+
+// creates path and writes it with write, failing on any error
+func writeFile(path string, write func(io.Writer) error) {
+	if err := gb.WriteFile(path, write); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func checkVectors(args []string) {
+	flags := flag.NewFlagSet("vectors", flag.ExitOnError)
+	all := flags.Bool("all", false, "list the opcodes that pass too")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fail()
+	}
+
+	results, err := singlestep.RunDir(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	passing := 0
+	for _, r := range results {
+		if r.Passes() {
+			passing++
+			if !*all {
+				continue
+			}
+		}
+
+		fmt.Printf("%-5s  %-14s  %4d/%d", formatOpcode(r.Opcode), r.Mnemonic, r.Passed, r.Cases)
+		if r.Failure != "" {
+			fmt.Printf("  %s", r.Failure)
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("%d of %d opcodes pass their vectors\n", passing, len(results))
+}
+
+func formatOpcode(op uint16) string {
+	if op > 0xff {
+		return fmt.Sprintf("cb %02x", byte(op))
+	}
+	return fmt.Sprintf("%02x", op)
+}
+
+func auditCpu(args []string) {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	vectors := flags.String("vectors", "", "directory of single step test vectors to check the instructions against")
+	flags.Parse(args)
+
+	var results []singlestep.Result
+	if *vectors != "" {
+		var err error
+		results, err = singlestep.RunDir(*vectors)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	audit.Generate(results)
+}
+
+func serveDap(args []string) {
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	listen := flags.String("listen", "", "serve on a tcp address like localhost:4711 instead of stdio")
+	flags.Parse(args)
+
+	var err error
+	if *listen == "" {
+		// stdout carries the protocol, so logs go to stderr
+		err = dap.Serve(os.Stdin, os.Stdout)
+	} else {
+		err = dap.ListenAndServe(*listen)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func disassemble(args []string) {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	bank := flags.Int("bank", 0, "rom bank")
+	from := flags.Uint("from", 0, "first address, defaults to the start of the bank")
+	to := flags.Uint("to", 0, "last address, defaults to the end of the bank")
+
+	// flags may come before or after the rom
+	var rom string
+	for flags.Parse(args); flags.NArg() > 0; flags.Parse(args) {
+		rom, args = flags.Arg(0), flags.Args()[1:]
+	}
+
+	if rom == "" || *bank < 0 {
+		fail()
+	}
+
+	start := uint(0)
+	if *bank > 0 {
+		start = 0x4000
+	}
+	if *from == 0 {
+		*from = start
+	}
+	if *to == 0 {
+		*to = start + 0x3fff
+	}
+
+	data, err := os.ReadFile(rom)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	syms, err := symbols.ForRom(rom)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lines, err := disasm.Rom(data, *bank, uint16(*from), uint16(*to), syms)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := disasm.Write(os.Stdout, lines); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func traceDiff(args []string) {
+	flags := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	context := flags.Int("context", 8, "instructions to show before the divergence")
+	romPath := flags.String("rom", "", "rom to disassemble the code around the divergence from")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		fail()
+	}
+
+	var rom []byte
+	var syms *symbols.Table
+	if *romPath != "" {
+		var err error
+		if rom, err = os.ReadFile(*romPath); err != nil {
+			log.Fatal(err)
+		}
+		if syms, err = symbols.ForRom(*romPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	nameA, nameB := flags.Arg(0), flags.Arg(1)
+
+	a, err := tracediff.Open(nameA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer a.Close()
+
+	b, err := tracediff.Open(nameB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer b.Close()
+
+	divergence, compared, err := tracediff.Diff(a, b, *context)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if divergence == nil {
+		fmt.Printf("traces match, %d instructions\n", compared)
+		return
+	}
+
+	divergence.Write(os.Stdout, nameA, nameB, rom, syms)
+	a.Close()
+	b.Close()
+	os.Exit(1)
+}
+
+// settings for emulate
+type options struct {
+	rewindSeconds     float64
+	rewindGranularity int
+	frameSkip         bool
+	ramPattern        gb.RamPattern
+	syncMode          gb.SyncMode
+
+	// file to trace to, if any
+	trace     string
+	traceOpts gb.TraceOptions
+
+	// address to serve gdb on, if any
+	gdb string
+
+	// code/data log file to add to, if any
+	cdl string
+
+	// where crash reports go, if anywhere
+	crashDir string
+
+	unimplemented gb.UnimplementedPolicy
+}
+
+// instructions kept for crash reports
+const crashHistory = 256
+
+// parses "from-to", inclusive
+func parseRange(s string, base, bits int) (from, to uint64, ok bool) {
+	fromStr, toStr, found := strings.Cut(s, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	from, err := strconv.ParseUint(strings.TrimPrefix(fromStr, "0x"), base, bits)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	to, err = strconv.ParseUint(strings.TrimPrefix(toStr, "0x"), base, bits)
+	if err != nil || to < from {
+		return 0, 0, false
+	}
+
+	return from, to, true
+}
+
+var ramPatterns = map[string]gb.RamPattern{
+	"zero":   gb.RamZero,
+	"random": gb.RamRandom,
+	"dmg":    gb.RamDmg,
+}
+
+var unimplementedPolicies = map[string]gb.UnimplementedPolicy{
+	"ignore": gb.UnimplementedIgnore,
+	"log":    gb.UnimplementedLog,
+	"pause":  gb.UnimplementedPause,
+	"fail":   gb.UnimplementedFail,
+}
+
+// audio sync needs an audio frontend, so it isn't offered here
+var syncModes = map[string]gb.SyncMode{
+	"timer": gb.SyncTimer,
+	"vsync": gb.SyncVsync,
+}
+
+func runGl(rom string, opts options) {
+	gameboy := gb.NewGb()
+	display := app.NewDisplay(gameboy, rom)
 	defer display.Destroy()
+	display.SetVSync(opts.syncMode == gb.SyncVsync)
+
+	emulate(gameboy, display, rom, opts)
+}
+
+func runTerm(rom string, opts options) {
+	gameboy := gb.NewGb()
+	terminal, err := app.NewTerminal(gameboy, rom)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer terminal.Destroy()
+
+	emulate(gameboy, terminal, rom, opts)
+}
+
+func emulate(gameboy *gb.Gb, renderer gb.Renderer, rom string, opts options) {
+	gameboy.ConnectDisplay(renderer)
+	gameboy.LoadCartridge(rom)
+	gameboy.EnableRewind(opts.rewindSeconds, opts.rewindGranularity)
+	gameboy.SetFrameSkip(opts.frameSkip)
+	gameboy.SetRamPattern(opts.ramPattern)
+	gameboy.SetSyncMode(opts.syncMode)
+	gameboy.EnableCrashReports(opts.crashDir, crashHistory)
+	gameboy.SetUnimplementedPolicy(opts.unimplemented)
+
+	if opts.trace != "" {
+		f, err := os.Create(opts.trace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		gameboy.StartTrace(f, opts.traceOpts)
+	}
 
-	gb.ConnectDisplay(display)
-	gb.LoadCartridge("./rom/tetris.gb")
+	var cdl gb.CodeDataLog
+	if opts.cdl != "" {
+		info, err := os.Stat(rom)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	gb.Run()
+		if cdl, err = coverage.Load(opts.cdl, int(info.Size())); err != nil {
+			log.Fatal(err)
+		}
+		gameboy.StartCodeDataLog(cdl)
+	}
+
+	if opts.gdb != "" {
+		// boot now, so the debugger doesn't race Run booting it
+		gameboy.Pause()
+		gameboy.Reset(false)
+
+		syms, err := symbols.ForRom(rom)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		server := gdbstub.NewServer(gameboy, syms)
+		go func() { log.Fatal(server.ListenAndServe(opts.gdb)) }()
+	}
+
+	err := gameboy.Run(context.Background())
+	if traceErr := gameboy.StopTrace(); traceErr != nil {
+		log.Print(traceErr)
+	}
+
+	if cdl != nil {
+		gameboy.StopCodeDataLog()
+		if cdlErr := coverage.Save(opts.cdl, cdl); cdlErr != nil {
+			log.Print(cdlErr)
+		}
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func fail() {
-	log.Fatal("Usage: goboy <run|audit>")
+	log.Fatal("Usage: goboy <run [--ui gl|term] [--cdl file] [--crash-dir dir] [--unimplemented ignore|log|pause|fail] [rom]|debug [--history seconds] [rom]|disasm [--bank n] [--from addr] [--to addr] rom|tracediff [--context n] [--rom rom] a.log b.log|profile [--frames n] [--top n] [--pprof file] [rom]|coverage [--cdl file] [--lcov file] [--html file] rom|dap [--listen addr]|vectors [--all] dir|audit [--vectors dir]>")
 }
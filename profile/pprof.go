@@ -0,0 +1,165 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/justinawrey/goboy/gb"
+	"github.com/justinawrey/goboy/symbols"
+)
+
+// WritePprof writes p as a gzipped pprof profile, for go tool pprof.
+// instructions are its locations, at bank<<16|addr, in functions named
+// for their routines.  the lines are the addresses, so pprof's views by
+// line show instructions
+func WritePprof(w io.Writer, p *gb.Profile, syms *symbols.Table) error {
+	var b pprofBuilder
+	b.strings = map[string]int{"": 0}
+	b.table = []string{""}
+	b.functions = make(map[string]int)
+	b.locations = make(map[pprofLocation]int)
+
+	var out protoBuf
+	for _, vt := range [][2]string{{"instructions", "count"}, {"cycles", "cycles"}} {
+		var t protoBuf
+		t.varint(1, uint64(b.str(vt[0])))
+		t.varint(2, uint64(b.str(vt[1])))
+		out.bytes(1, t)
+	}
+
+	for _, s := range p.Samples {
+		ids := make([]uint64, len(s.Stack))
+		for i, loc := range s.Stack {
+			ids[i] = uint64(b.location(loc, syms))
+		}
+
+		var sample protoBuf
+		sample.packed(1, ids)
+		sample.packed(2, []uint64{s.Instructions, s.Cycles})
+		out.bytes(2, sample)
+	}
+
+	for _, loc := range b.locationList {
+		out.bytes(4, loc)
+	}
+	for _, fn := range b.functionList {
+		out.bytes(5, fn)
+	}
+	for _, s := range b.table {
+		out.bytes(6, []byte(s))
+	}
+
+	out.varint(10, uint64(p.Duration))
+	// cycles are what a flame graph should be sized by
+	out.varint(14, uint64(b.str("cycles")))
+
+	z := gzip.NewWriter(w)
+	if _, err := z.Write(out); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+type pprofLocation struct {
+	bank    int
+	addr    uint16
+	routine string
+}
+
+// interns the strings, functions and locations of a profile, which are
+// referred to by index and id
+type pprofBuilder struct {
+	strings map[string]int
+	table   []string
+
+	functions    map[string]int
+	functionList []protoBuf
+
+	locations    map[pprofLocation]int
+	locationList []protoBuf
+}
+
+func (b *pprofBuilder) str(s string) int {
+	i, ok := b.strings[s]
+	if !ok {
+		i = len(b.table)
+		b.strings[s] = i
+		b.table = append(b.table, s)
+	}
+	return i
+}
+
+func (b *pprofBuilder) function(name string, addr uint16) int {
+	id, ok := b.functions[name]
+	if ok {
+		return id
+	}
+
+	id = len(b.functionList) + 1
+	b.functions[name] = id
+
+	var fn protoBuf
+	fn.varint(1, uint64(id))
+	fn.varint(2, uint64(b.str(name)))
+	fn.varint(5, uint64(addr))
+	b.functionList = append(b.functionList, fn)
+	return id
+}
+
+func (b *pprofBuilder) location(loc gb.ProfileLocation, syms *symbols.Table) int {
+	name, _, addr := routine(loc, syms)
+	key := pprofLocation{bank: loc.Bank, addr: loc.Addr, routine: name}
+
+	id, ok := b.locations[key]
+	if ok {
+		return id
+	}
+
+	id = len(b.locationList) + 1
+	b.locations[key] = id
+
+	var line protoBuf
+	line.varint(1, uint64(b.function(name, addr)))
+	line.varint(2, uint64(loc.Addr))
+
+	var l protoBuf
+	l.varint(1, uint64(id))
+	l.varint(3, uint64(loc.Bank)<<16|uint64(loc.Addr))
+	l.bytes(4, line)
+	b.locationList = append(b.locationList, l)
+	return id
+}
+
+// just enough of protobuf's wire format to write a profile
+type protoBuf []byte
+
+func (p *protoBuf) uvarint(n uint64) {
+	for n >= 0x80 {
+		*p = append(*p, byte(n)|0x80)
+		n >>= 7
+	}
+	*p = append(*p, byte(n))
+}
+
+// zero is the default, so it's left out
+func (p *protoBuf) varint(field int, n uint64) {
+	if n == 0 {
+		return
+	}
+	p.uvarint(uint64(field) << 3)
+	p.uvarint(n)
+}
+
+func (p *protoBuf) bytes(field int, b []byte) {
+	p.uvarint(uint64(field)<<3 | 2)
+	p.uvarint(uint64(len(b)))
+	*p = append(*p, b...)
+}
+
+func (p *protoBuf) packed(field int, ns []uint64) {
+	var b protoBuf
+	for _, n := range ns {
+		b.uvarint(n)
+	}
+	p.bytes(field, b)
+}
@@ -0,0 +1,141 @@
+package profile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/justinawrey/goboy/gb"
+	"github.com/justinawrey/goboy/symbols"
+)
+
+// Routine is the cycles spent in one routine
+type Routine struct {
+	Name string
+
+	// where it starts, from its symbol or the calls made to it
+	Bank int
+	Addr uint16
+
+	// in its own instructions, and including what it called
+	Flat uint64
+	Cum  uint64
+
+	Instructions uint64
+}
+
+// routine names the routine loc is in: its symbol, else where call
+// tracking says its call went
+func routine(loc gb.ProfileLocation, syms *symbols.Table) (name string, bank int, addr uint16) {
+	if sym, ok := syms.Routine(loc.Bank, loc.Addr); ok {
+		return sym.Name, sym.Bank, sym.Addr
+	}
+
+	if loc.InRoutine {
+		return fmt.Sprintf("L%02x_%04x", loc.RoutineBank, loc.Routine), loc.RoutineBank, loc.Routine
+	}
+
+	return topLevel, 0, 0
+}
+
+// code outside any call, without symbols to say what it is
+const topLevel = "(top level)"
+
+// Routines rolls p up into routines, busiest first
+func Routines(p *gb.Profile, syms *symbols.Table) []Routine {
+	byName := make(map[string]*Routine)
+	var order []string
+
+	get := func(loc gb.ProfileLocation) *Routine {
+		name, bank, addr := routine(loc, syms)
+		r, ok := byName[name]
+		if !ok {
+			r = &Routine{Name: name, Bank: bank, Addr: addr}
+			byName[name] = r
+			order = append(order, name)
+		}
+		return r
+	}
+
+	for _, s := range p.Samples {
+		leaf := get(s.Stack[0])
+		leaf.Flat += s.Cycles
+		leaf.Instructions += s.Instructions
+
+		// recursion only counts once towards cum
+		seen := make(map[*Routine]bool)
+		for _, loc := range s.Stack {
+			if r := get(loc); !seen[r] {
+				seen[r] = true
+				r.Cum += s.Cycles
+			}
+		}
+	}
+
+	routines := make([]Routine, 0, len(order))
+	for _, name := range order {
+		routines = append(routines, *byName[name])
+	}
+
+	sort.Slice(routines, func(i, j int) bool {
+		a, b := routines[i], routines[j]
+		if a.Flat != b.Flat {
+			return a.Flat > b.Flat
+		}
+		if a.Cum != b.Cum {
+			return a.Cum > b.Cum
+		}
+		return a.Name < b.Name
+	})
+
+	return routines
+}
+
+// Cycles is the total counted in p
+func Cycles(p *gb.Profile) uint64 {
+	var total uint64
+	for _, s := range p.Samples {
+		total += s.Cycles
+	}
+	return total
+}
+
+// Report writes the top busiest routines, like pprof's top, or all
+// of them if top is 0
+func Report(w io.Writer, p *gb.Profile, syms *symbols.Table, top int) error {
+	routines := Routines(p, syms)
+	total := Cycles(p)
+
+	percent := func(n uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(total)
+	}
+
+	fmt.Fprintf(w, "%d cycles in %d frames (%v)\n", total, p.Frames, p.Duration)
+	if top > 0 && top < len(routines) {
+		fmt.Fprintf(w, "showing the top %d of %d routines\n", top, len(routines))
+		routines = routines[:top]
+	}
+
+	fmt.Fprintf(w, "%12s %6s %6s %12s %6s  %s\n", "flat", "flat%", "sum%", "cum", "cum%", "routine")
+
+	var sum uint64
+	for _, r := range routines {
+		sum += r.Flat
+
+		where := ""
+		if r.Name != topLevel {
+			where = fmt.Sprintf(" (%02x:%04x)", r.Bank, r.Addr)
+		}
+
+		_, err := fmt.Fprintf(w, "%12d %5.1f%% %5.1f%% %12d %5.1f%%  %s%s\n",
+			r.Flat, percent(r.Flat), percent(sum), r.Cum, percent(r.Cum), r.Name, where)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,307 @@
+package singlestep
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/justinawrey/goboy/gb"
+)
+
+// Case is one of the sm83 single step test vectors: the state before and
+// after one instruction, and what it did on the bus each m-cycle.  they
+// come in a json file of cases for each opcode
+type Case struct {
+	Name    string  `json:"name"`
+	Initial State   `json:"initial"`
+	Final   State   `json:"final"`
+	Cycles  []Cycle `json:"cycles"`
+}
+
+// State is the cpu and the memory it touches.  the sm83 fetches each
+// opcode during the instruction before it, so pc is one past the opcode
+// about to execute, and a case's last cycle fetches the next one.  goboy
+// has no interrupts yet, so ime and ie are set up but never checked
+type State struct {
+	A   byte   `json:"a"`
+	B   byte   `json:"b"`
+	C   byte   `json:"c"`
+	D   byte   `json:"d"`
+	E   byte   `json:"e"`
+	F   byte   `json:"f"`
+	H   byte   `json:"h"`
+	L   byte   `json:"l"`
+	PC  uint16 `json:"pc"`
+	SP  uint16 `json:"sp"`
+	IME byte   `json:"ime"`
+	IE  byte   `json:"ie"`
+
+	// address and value pairs
+	RAM [][2]int `json:"ram"`
+}
+
+// Cycle is the bus during one m-cycle
+type Cycle struct {
+	// nothing was on the bus
+	Idle bool
+
+	Addr  uint16
+	Value byte
+	Read  bool
+	Write bool
+}
+
+// a cycle is null when idle, else [addr, value, "r-m"] with r or w for
+// the direction
+func (c *Cycle) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*c = Cycle{Idle: true}
+		return nil
+	}
+
+	var raw [3]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	addr, ok1 := raw[0].(float64)
+	value, ok2 := raw[1].(float64)
+	pins, ok3 := raw[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return fmt.Errorf("singlestep: bad cycle %s", b)
+	}
+
+	*c = Cycle{
+		Addr:  uint16(addr),
+		Value: byte(value),
+		Read:  strings.HasPrefix(pins, "r"),
+		Write: strings.Contains(pins, "w"),
+	}
+	return nil
+}
+
+// Result is how an opcode fared against its vectors
+type Result struct {
+	// 16 bits for the cb prefixed ones.  stop is 0x10
+	Opcode uint16
+
+	Mnemonic string
+
+	Cases, Passed int
+
+	// the first case to fail, and how
+	Failure string
+}
+
+// Passes is whether every case passed
+func (r Result) Passes() bool {
+	return r.Cases > 0 && r.Passed == r.Cases
+}
+
+// Opcode is the opcode a vector file is for, from names like 3e.json and
+// cb 7c.json
+func Opcode(path string) (uint16, bool) {
+	name := strings.TrimSuffix(filepath.Base(path), ".json")
+	prefixed := strings.HasPrefix(name, "cb ")
+	name = strings.TrimPrefix(name, "cb ")
+
+	op, err := strconv.ParseUint(name, 16, 8)
+	if err != nil || len(name) != 2 {
+		return 0, false
+	}
+
+	if prefixed {
+		return 0xcb00 | uint16(op), true
+	}
+	return uint16(op), true
+}
+
+// Load reads a vector file
+func Load(path string) ([]Case, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cases []Case
+	if err := json.Unmarshal(data, &cases); err != nil {
+		return nil, fmt.Errorf("singlestep: %s: %v", path, err)
+	}
+	return cases, nil
+}
+
+// RunFile runs every case in a vector file
+func RunFile(path string) (Result, error) {
+	op, ok := Opcode(path)
+	if !ok {
+		return Result{}, fmt.Errorf("singlestep: %s isn't named for an opcode", path)
+	}
+
+	cases, err := Load(path)
+	if err != nil {
+		return Result{}, err
+	}
+
+	r := Result{Opcode: op, Mnemonic: mnemonic(op), Cases: len(cases)}
+	for _, c := range cases {
+		problems := Run(c)
+		if len(problems) == 0 {
+			r.Passed++
+			continue
+		}
+
+		if r.Failure == "" {
+			r.Failure = fmt.Sprintf("%s: %s", c.Name, strings.Join(problems, ", "))
+		}
+	}
+
+	return r, nil
+}
+
+// RunDir runs every vector file in dir, sorted by opcode
+func RunDir(dir string) ([]Result, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("singlestep: no vector files in %s", dir)
+	}
+
+	var results []Result
+	for _, path := range paths {
+		if _, ok := Opcode(path); !ok {
+			continue
+		}
+
+		r, err := RunFile(path)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Opcode < results[j].Opcode })
+	return results, nil
+}
+
+// Run executes c, returning how the result differs from its final state.
+// none means it passed
+func Run(c Case) []string {
+	mem := make([]byte, 0x10000)
+	fetched := false
+	for _, kv := range c.Initial.RAM {
+		mem[uint16(kv[0])] = byte(kv[1])
+		fetched = fetched || uint16(kv[0]) == c.Initial.PC-1
+	}
+	mem[0xffff] = c.Initial.IE
+
+	// the opcode already fetched needn't be in ram, but the case is named
+	// for it, like "cb 7c 0012"
+	if !fetched {
+		name, _, _ := strings.Cut(c.Name, " ")
+		if op, err := strconv.ParseUint(name, 16, 8); err == nil {
+			mem[c.Initial.PC-1] = byte(op)
+		}
+	}
+
+	// goboy fetches the opcode when it executes it, so it starts
+	// before the opcode and ends before the next one
+	in := c.Initial
+	res := gb.SingleStep(gb.Registers{
+		A: in.A, F: in.F, B: in.B, C: in.C,
+		D: in.D, E: in.E, H: in.H, L: in.L,
+		SP: in.SP, PC: in.PC - 1,
+	}, mem)
+	if res.Err != nil {
+		return []string{res.Err.Error()}
+	}
+
+	var problems []string
+	mismatch := func(what string, got, want interface{}) {
+		problems = append(problems, fmt.Sprintf("%s %02x, want %02x", what, got, want))
+	}
+
+	want := c.Final
+	regs := []struct {
+		name      string
+		got, want byte
+	}{
+		{"a", res.A, want.A}, {"f", res.F, want.F},
+		{"b", res.B, want.B}, {"c", res.C, want.C},
+		{"d", res.D, want.D}, {"e", res.E, want.E},
+		{"h", res.H, want.H}, {"l", res.L, want.L},
+	}
+	for _, r := range regs {
+		if r.got != r.want {
+			mismatch(r.name, r.got, r.want)
+		}
+	}
+	if res.SP != want.SP {
+		mismatch("sp", res.SP, want.SP)
+	}
+	if res.PC+1 != want.PC {
+		mismatch("pc", res.PC+1, want.PC)
+	}
+
+	for _, kv := range want.RAM {
+		addr, v := uint16(kv[0]), byte(kv[1])
+		if mem[addr] != v {
+			mismatch(fmt.Sprintf("$%04x", addr), mem[addr], v)
+		}
+	}
+
+	if cycles := 4 * len(c.Cycles); res.Cycles != cycles {
+		problems = append(problems, fmt.Sprintf("%d cycles, want %d", res.Cycles, cycles))
+	}
+
+	var writes []gb.Access
+	for _, cycle := range c.Cycles {
+		if cycle.Write {
+			writes = append(writes, gb.Access{Addr: cycle.Addr, New: cycle.Value})
+		}
+	}
+	if !sameWrites(res.Writes, writes) {
+		problems = append(problems, fmt.Sprintf("wrote %s, want %s", formatWrites(res.Writes), formatWrites(writes)))
+	}
+
+	return problems
+}
+
+func sameWrites(a, b []gb.Access) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Addr != b[i].Addr || a[i].New != b[i].New {
+			return false
+		}
+	}
+	return true
+}
+
+func formatWrites(writes []gb.Access) string {
+	if len(writes) == 0 {
+		return "nothing"
+	}
+
+	s := make([]string, len(writes))
+	for i, w := range writes {
+		s[i] = fmt.Sprintf("%02x to $%04x", w.New, w.Addr)
+	}
+	return strings.Join(s, " then ")
+}
+
+func mnemonic(op uint16) string {
+	if op > 0xff {
+		return gb.InstructionTable16[op].Mnemonic
+	}
+	if op == 0x10 {
+		return gb.InstructionTable16[0x1000].Mnemonic
+	}
+	return gb.InstructionTable8[uint8(op)].Mnemonic
+}
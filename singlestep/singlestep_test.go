@@ -0,0 +1,74 @@
+package singlestep
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// ld a,$42 with its opcode already fetched from $1000 and not in ram,
+// and ld (hl),a storing $aa to $c000
+const testCases = `[
+{"name": "3e 0000",
+ "initial": {"a": 0, "b": 0, "c": 0, "d": 0, "e": 0, "f": 0, "h": 0, "l": 0, "pc": 4097, "sp": 0, "ime": 0, "ie": 0,
+  "ram": [[4097, 66], [4098, 0]]},
+ "final": {"a": 66, "b": 0, "c": 0, "d": 0, "e": 0, "f": 0, "h": 0, "l": 0, "pc": 4099, "sp": 0, "ime": 0, "ie": 0,
+  "ram": [[4097, 66], [4098, 0]]},
+ "cycles": [[4097, 66, "r-m"], [4098, 0, "r-m"]]},
+{"name": "77 0000",
+ "initial": {"a": 170, "b": 0, "c": 0, "d": 0, "e": 0, "f": 0, "h": 192, "l": 0, "pc": 4097, "sp": 0, "ime": 0, "ie": 0,
+  "ram": [[4096, 119], [4097, 0]]},
+ "final": {"a": 170, "b": 0, "c": 0, "d": 0, "e": 0, "f": 0, "h": 192, "l": 0, "pc": 4098, "sp": 0, "ime": 0, "ie": 0,
+  "ram": [[4096, 119], [4097, 0], [49152, 170]]},
+ "cycles": [[49152, 170, "-wm"], [4097, 0, "r-m"]]}
+]`
+
+func loadCases(t *testing.T) (ld, store Case) {
+	var cases []Case
+	if err := json.Unmarshal([]byte(testCases), &cases); err != nil {
+		t.Fatal(err)
+	}
+	return cases[0], cases[1]
+}
+
+func TestRunPasses(t *testing.T) {
+	ld, store := loadCases(t)
+
+	for _, c := range []Case{ld, store} {
+		if problems := Run(c); len(problems) != 0 {
+			t.Errorf("%s: %v", c.Name, problems)
+		}
+	}
+}
+
+func TestRunWrongRegister(t *testing.T) {
+	ld, _ := loadCases(t)
+	ld.Final.A = 0x43
+
+	problems := Run(ld)
+	if len(problems) != 1 || problems[0] != "a 42, want 43" {
+		t.Errorf("got %v, want a mismatch", problems)
+	}
+}
+
+func TestRunMissingWrite(t *testing.T) {
+	_, store := loadCases(t)
+	store.Cycles = append([]Cycle{{Addr: 0xc001, Value: 0xaa, Write: true}}, store.Cycles...)
+
+	problems := Run(store)
+	if len(problems) != 2 || problems[0] != "8 cycles, want 12" || !strings.HasPrefix(problems[1], "wrote aa to $c000, want aa to $c001 then") {
+		t.Errorf("got %v, want a cycle count and a write mismatch", problems)
+	}
+}
+
+func TestOpcode(t *testing.T) {
+	for name, want := range map[string]uint16{"3e.json": 0x3e, "dir/cb 7c.json": 0xcb7c} {
+		if op, ok := Opcode(name); !ok || op != want {
+			t.Errorf("%s: got %04x, %v, want %04x", name, op, ok, want)
+		}
+	}
+
+	if _, ok := Opcode("readme.json"); ok {
+		t.Error("readme.json isn't an opcode")
+	}
+}
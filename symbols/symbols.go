@@ -0,0 +1,294 @@
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbol is a label rgblink placed at an address in a bank
+type Symbol struct {
+	Name string
+	Bank int
+	Addr uint16
+}
+
+// Table holds a rom's symbols, looked up by name or by address
+type Table struct {
+	byName map[string]Symbol
+
+	// sorted by bank, then address
+	byAddr []Symbol
+}
+
+// starts of the memory regions, a symbol only names addresses in its own
+var regions = []uint16{0x0000, 0x4000, 0x8000, 0xa000, 0xc000, 0xd000, 0xe000, 0xfe00, 0xfea0, 0xff00, 0xff80, 0xffff}
+
+func region(addr uint16) int {
+	return sort.Search(len(regions), func(i int) bool { return regions[i] > addr }) - 1
+}
+
+// BankAt is the bank rgblink gives addresses at addr, while romBank is
+// switched into 0x4000 - 0x7fff
+func BankAt(addr uint16, romBank int) int {
+	switch {
+	case addr >= 0x4000 && addr < 0x8000:
+		return romBank
+	case addr >= 0xd000 && addr < 0xe000:
+		// wramx, which is always bank 1 on the dmg
+		return 1
+	}
+
+	return 0
+}
+
+// ForRom loads the .sym file next to a rom, or failing that its .map file.
+// it returns nil, nil if there is neither
+func ForRom(rom string) (*Table, error) {
+	base := strings.TrimSuffix(rom, filepath.Ext(rom))
+
+	for _, path := range []string{base + ".sym", base + ".map"} {
+		if _, err := os.Stat(path); err == nil {
+			return Load(path)
+		}
+	}
+
+	return nil, nil
+}
+
+// Load reads an rgblink .map file, or a .sym file for any other extension
+func Load(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &Table{byName: make(map[string]Symbol)}
+
+	if strings.EqualFold(filepath.Ext(path), ".map") {
+		err = t.readMap(f)
+	} else {
+		err = t.readSym(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	sort.SliceStable(t.byAddr, func(i, j int) bool {
+		a, b := t.byAddr[i], t.byAddr[j]
+		if a.Bank != b.Bank {
+			return a.Bank < b.Bank
+		}
+		return a.Addr < b.Addr
+	})
+
+	return t, nil
+}
+
+// "bank:addr name" per line, with ; comments
+func (t *Table) readSym(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), ";")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		bank, addr, ok := parseBankAddr(fields[0])
+		if !ok {
+			continue
+		}
+
+		t.add(Symbol{Name: fields[1], Bank: bank, Addr: addr})
+	}
+
+	return scanner.Err()
+}
+
+var (
+	mapBank   = regexp.MustCompile(`^\s*\w+ bank #(\d+):`)
+	mapSymbol = regexp.MustCompile(`^\s*\$([0-9a-fA-F]{4}) = (\S+)`)
+)
+
+// symbols are listed under the "ROMX bank #2:" style heading of their bank
+func (t *Table) readMap(r io.Reader) error {
+	bank := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := mapBank.FindStringSubmatch(line); m != nil {
+			n, err := strconv.Atoi(m[1])
+			if err != nil {
+				return err
+			}
+			bank = n
+			continue
+		}
+
+		if m := mapSymbol.FindStringSubmatch(line); m != nil {
+			addr, _ := strconv.ParseUint(m[1], 16, 16)
+			t.add(Symbol{Name: m[2], Bank: bank, Addr: uint16(addr)})
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (t *Table) add(sym Symbol) {
+	if _, ok := t.byName[sym.Name]; ok {
+		return
+	}
+
+	t.byName[sym.Name] = sym
+	t.byAddr = append(t.byAddr, sym)
+}
+
+// Len is how many symbols there are.  a nil Table has none
+func (t *Table) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.byAddr)
+}
+
+// Lookup finds a symbol by name
+func (t *Table) Lookup(name string) (Symbol, bool) {
+	if t == nil {
+		return Symbol{}, false
+	}
+
+	sym, ok := t.byName[name]
+	return sym, ok
+}
+
+// Addr looks up a symbol's address, for naming them in expressions
+func (t *Table) Addr(name string) (uint16, bool) {
+	sym, ok := t.Lookup(name)
+	return sym.Addr, ok
+}
+
+// Nearest finds the last symbol at or before addr in bank, within the
+// same memory region
+func (t *Table) Nearest(bank int, addr uint16) (Symbol, bool) {
+	if t == nil {
+		return Symbol{}, false
+	}
+
+	i := sort.Search(len(t.byAddr), func(i int) bool {
+		sym := t.byAddr[i]
+		return sym.Bank > bank || sym.Bank == bank && sym.Addr > addr
+	}) - 1
+
+	if i < 0 {
+		return Symbol{}, false
+	}
+
+	sym := t.byAddr[i]
+	if sym.Bank != bank || region(sym.Addr) != region(addr) {
+		return Symbol{}, false
+	}
+
+	return sym, true
+}
+
+// Routine finds the symbol of the routine addr is in: Nearest, with a
+// local label like "Main.loop" given as the "Main" it belongs to
+func (t *Table) Routine(bank int, addr uint16) (Symbol, bool) {
+	sym, ok := t.Nearest(bank, addr)
+	if !ok {
+		return sym, false
+	}
+
+	if parent, _, local := strings.Cut(sym.Name, "."); local && parent != "" {
+		if p, ok := t.Lookup(parent); ok && p.Bank == sym.Bank && p.Addr <= sym.Addr {
+			return p, true
+		}
+	}
+
+	return sym, true
+}
+
+// At finds the symbol at exactly addr in bank
+func (t *Table) At(bank int, addr uint16) (string, bool) {
+	sym, ok := t.Nearest(bank, addr)
+	if !ok || sym.Addr != addr {
+		return "", false
+	}
+
+	return sym.Name, true
+}
+
+// Describe names addr after the nearest symbol, like "Main+$3", or
+// as a plain address if there isn't one
+func (t *Table) Describe(bank int, addr uint16) string {
+	sym, ok := t.Nearest(bank, addr)
+	switch {
+	case !ok:
+		return fmt.Sprintf("$%04x", addr)
+	case sym.Addr == addr:
+		return sym.Name
+	}
+
+	return fmt.Sprintf("%s+$%x", sym.Name, addr-sym.Addr)
+}
+
+// Resolve parses a symbol name, or an address written as $0150, 0x150,
+// 0150 or with a bank as 01:4000.  addresses without a bank get the
+// one BankAt gives them with bank 1 switched in
+func (t *Table) Resolve(s string) (Symbol, error) {
+	if sym, ok := t.Lookup(s); ok {
+		return sym, nil
+	}
+
+	if strings.Contains(s, ":") {
+		bank, addr, ok := parseBankAddr(s)
+		if !ok {
+			return Symbol{}, fmt.Errorf("bad address %q", s)
+		}
+		return Symbol{Bank: bank, Addr: addr}, nil
+	}
+
+	addr, err := strconv.ParseUint(trimHex(s), 16, 16)
+	if err != nil {
+		if t.Len() > 0 {
+			return Symbol{}, fmt.Errorf("no symbol or address %q", s)
+		}
+		return Symbol{}, fmt.Errorf("bad address %q", s)
+	}
+
+	return Symbol{Bank: BankAt(uint16(addr), 1), Addr: uint16(addr)}, nil
+}
+
+// parses "bb:aaaa"
+func parseBankAddr(s string) (int, uint16, bool) {
+	bankStr, addrStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, false
+	}
+
+	bank, err := strconv.ParseUint(trimHex(bankStr), 16, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	addr, err := strconv.ParseUint(trimHex(addrStr), 16, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return int(bank), uint16(addr), true
+}
+
+func trimHex(s string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+}
@@ -0,0 +1,300 @@
+package tracediff
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/justinawrey/goboy/disasm"
+	"github.com/justinawrey/goboy/symbols"
+)
+
+// longest trace line read, binjgb style lines with disassembly can get long
+const maxLine = 1024 * 1024
+
+// Divergence is where two traces first disagree
+type Divergence struct {
+	// index of the diverging state, from 0, not counting lines that
+	// aren't states
+	Index uint64
+
+	// the states leading up to the divergence, oldest first,
+	// which both traces agree on
+	Context []State
+
+	// the first states that differ.  a trace that ended early
+	// has a nil state
+	A, B *State
+}
+
+// Diff streams two traces and returns the first point where they
+// disagree, with up to context states before it, and how many states
+// it compared.  registers only one of the traces logs are not compared.
+// the divergence is nil if they match
+func Diff(a, b io.Reader, context int) (*Divergence, uint64, error) {
+	as, bs := newReader(a), newReader(b)
+	var history []State
+	var index uint64
+
+	for ; ; index++ {
+		sa, okA := as.next()
+		sb, okB := bs.next()
+
+		if err := as.err(); err != nil {
+			return nil, index, err
+		}
+		if err := bs.err(); err != nil {
+			return nil, index, err
+		}
+
+		if !okA && !okB {
+			return nil, index, nil
+		}
+
+		if !okA || !okB || len(Differences(sa, sb)) > 0 {
+			d := &Divergence{Index: index, Context: history}
+			if okA {
+				d.A = &sa
+			}
+			if okB {
+				d.B = &sb
+			}
+			return d, index, nil
+		}
+
+		if context > 0 {
+			if len(history) == context {
+				history = history[1:]
+			}
+			history = append(history, sa)
+		}
+	}
+}
+
+// Differences lists the registers that differ between a and b, of those
+// both have.  the low bits of F are always 0 on hardware, so they're ignored
+func Differences(a, b State) []string {
+	both := a.fields & b.fields
+	var diffs []string
+
+	values := func(s State) []int {
+		return []int{
+			int(s.A), int(s.F & 0xf0), int(s.B), int(s.C), int(s.D), int(s.E),
+			int(s.H), int(s.L), int(s.SP), int(s.PC),
+		}
+	}
+	va, vb := values(a), values(b)
+
+	for i, f := range fieldNames {
+		if both&f.field == 0 {
+			continue
+		}
+
+		if f.field == fieldMem {
+			n := len(a.Mem)
+			if len(b.Mem) < n {
+				n = len(b.Mem)
+			}
+			if string(a.Mem[:n]) != string(b.Mem[:n]) {
+				diffs = append(diffs, f.name)
+			}
+			continue
+		}
+
+		if va[i] != vb[i] {
+			diffs = append(diffs, f.name)
+		}
+	}
+
+	return diffs
+}
+
+// Write describes the divergence, disassembling the code around it.
+// without the rom, only instructions the traces logged bytes for are shown.
+// syms, which may be nil, labels the code
+func (d *Divergence) Write(w io.Writer, nameA, nameB string, rom []byte, syms *symbols.Table) {
+	fmt.Fprintf(w, "traces diverge at instruction %d\n\n", d.Index)
+
+	for _, s := range d.Context {
+		fmt.Fprintf(w, "  %6d  %s\n", s.Line, describe(s, syms))
+	}
+
+	writeState(w, nameA, d.A, syms)
+	writeState(w, nameB, d.B, syms)
+
+	if d.A != nil && d.B != nil {
+		fmt.Fprintln(w)
+		for _, name := range Differences(*d.A, *d.B) {
+			fmt.Fprintf(w, "  %-5s %s\n", name, compare(name, *d.A, *d.B))
+		}
+	}
+
+	if rom != nil {
+		var pc uint16
+		switch {
+		case d.A != nil:
+			pc = d.A.PC
+		case len(d.Context) > 0:
+			pc = d.Context[len(d.Context)-1].PC
+		}
+		fmt.Fprintln(w)
+		writeCode(w, rom, d.Context, pc, syms)
+	}
+}
+
+func writeState(w io.Writer, name string, s *State, syms *symbols.Table) {
+	if s == nil {
+		fmt.Fprintf(w, "> %s ended\n", name)
+		return
+	}
+
+	fmt.Fprintf(w, "> %6d  %s    (%s)\n", s.Line, describe(*s, syms), name)
+}
+
+// the registers a state has, its instruction if the trace logged the bytes,
+// and the symbol it's in.  without a mapper to go on, bank 1 is assumed
+func describe(s State, syms *symbols.Table) string {
+	var b strings.Builder
+
+	for _, f := range fieldNames {
+		if s.fields&f.field == 0 || f.field == fieldMem {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%s ", f.name, value(f.name, s))
+	}
+
+	if len(s.Mem) > 0 {
+		text, _ := disasm.Labeled(s.Mem, s.PC, syms, 1)
+		fmt.Fprintf(&b, "| %s ", text)
+	}
+	if _, ok := syms.Nearest(symbols.BankAt(s.PC, 1), s.PC); ok {
+		fmt.Fprintf(&b, "; %s", syms.Describe(symbols.BankAt(s.PC, 1), s.PC))
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+func value(name string, s State) string {
+	switch name {
+	case "A":
+		return fmt.Sprintf("%02X", s.A)
+	case "F":
+		return fmt.Sprintf("%02X", s.F)
+	case "B":
+		return fmt.Sprintf("%02X", s.B)
+	case "C":
+		return fmt.Sprintf("%02X", s.C)
+	case "D":
+		return fmt.Sprintf("%02X", s.D)
+	case "E":
+		return fmt.Sprintf("%02X", s.E)
+	case "H":
+		return fmt.Sprintf("%02X", s.H)
+	case "L":
+		return fmt.Sprintf("%02X", s.L)
+	case "SP":
+		return fmt.Sprintf("%04X", s.SP)
+	case "PC":
+		return fmt.Sprintf("%04X", s.PC)
+	case "PCMEM":
+		return fmt.Sprintf("% X", s.Mem)
+	}
+
+	return "?"
+}
+
+func compare(name string, a, b State) string {
+	if name != "F" {
+		return fmt.Sprintf("%s vs %s", value(name, a), value(name, b))
+	}
+
+	var flags []string
+	for i, flag := range "ZNHC" {
+		bit := byte(0x80 >> i)
+		if a.F&bit != b.F&bit {
+			flags = append(flags, string(flag))
+		}
+	}
+
+	return fmt.Sprintf("%s vs %s, %s differ", flagString(a.F), flagString(b.F), strings.Join(flags, " "))
+}
+
+func flagString(f byte) string {
+	flags := []byte("----")
+	for i, flag := range "ZNHC" {
+		if f&(0x80>>i) != 0 {
+			flags[i] = byte(flag)
+		}
+	}
+
+	return string(flags)
+}
+
+// disassembles from the oldest nearby context instruction up to a few
+// instructions past pc.  without a mapper to go on, bank 1 is assumed
+func writeCode(w io.Writer, rom []byte, context []State, pc uint16, syms *symbols.Table) {
+	if pc >= 0x8000 || int(pc) >= len(rom) {
+		fmt.Fprintf(w, "pc %04X is outside the rom\n", pc)
+		return
+	}
+
+	from := pc
+	for _, s := range context {
+		if s.PC < 0x8000 && s.PC < pc && pc-s.PC < 0x40 && s.PC < from {
+			from = s.PC
+		}
+	}
+
+	end := int(pc) + 16
+	if end > len(rom) {
+		end = len(rom)
+	}
+
+	after := 0
+	for _, line := range disasm.Disassemble(rom[from:end], int(from)/0x4000, from, syms) {
+		if line.Label != "" {
+			fmt.Fprintf(w, "  %s:\n", line.Label)
+		}
+
+		marker := " "
+		if line.Addr == pc {
+			marker = ">"
+		}
+		if line.Addr > pc {
+			if after++; after > 4 {
+				break
+			}
+		}
+		fmt.Fprintf(w, "%s %02x:%04x  %-8s  %s\n", marker, line.Bank, line.Addr, fmt.Sprintf("% x", line.Bytes), line.Text)
+	}
+}
+
+// reads the states of a trace, skipping other lines
+type reader struct {
+	scanner *bufio.Scanner
+	line    uint64
+}
+
+func newReader(r io.Reader) *reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	return &reader{scanner: scanner}
+}
+
+func (r *reader) next() (State, bool) {
+	for r.scanner.Scan() {
+		r.line++
+
+		if s, ok := Parse(r.scanner.Text()); ok {
+			s.Line = r.line
+			return s, true
+		}
+	}
+
+	return State{}, false
+}
+
+func (r *reader) err() error {
+	return r.scanner.Err()
+}
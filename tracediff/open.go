@@ -0,0 +1,49 @@
+package tracediff
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+type plainFile struct {
+	*bufio.Reader
+	f *os.File
+}
+
+func (p plainFile) Close() error {
+	return p.f.Close()
+}
+
+// Open opens a trace file, decompressing it if it is gzipped
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(f)
+	magic, _ := r.Peek(2)
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return plainFile{r, f}, nil
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return gzipFile{gz, f}, nil
+}
@@ -0,0 +1,150 @@
+package tracediff
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// which registers a trace line had
+type field uint16
+
+const (
+	fieldA field = 1 << iota
+	fieldF
+	fieldB
+	fieldC
+	fieldD
+	fieldE
+	fieldH
+	fieldL
+	fieldSP
+	fieldPC
+	fieldMem
+)
+
+var fieldNames = []struct {
+	field field
+	name  string
+}{
+	{fieldA, "A"}, {fieldF, "F"}, {fieldB, "B"}, {fieldC, "C"},
+	{fieldD, "D"}, {fieldE, "E"}, {fieldH, "H"}, {fieldL, "L"},
+	{fieldSP, "SP"}, {fieldPC, "PC"}, {fieldMem, "PCMEM"},
+}
+
+// State is the cpu state on one line of a trace.  traces log it
+// before the instruction at PC executes
+type State struct {
+	A, F, B, C, D, E, H, L byte
+	SP, PC                 uint16
+
+	// bytes at PC, if the trace has them
+	Mem []byte
+
+	// line number in the trace, from 1
+	Line uint64
+
+	fields field
+}
+
+// KEY:VALUE or KEY=VALUE, which covers gameboy-doctor's
+// "A:01 F:B0 ... PCMEM:00,C3,13,02", binjgb's "A:01 F:Z-HC BC:0013 ..."
+// and bgb style "AF=01B0 BC=0013 ..." traces
+var token = regexp.MustCompile(`\b([A-Za-z]+)\s*[:=]\s*([ZNHCznhc-]{4}|[$]?(?:0x)?[0-9A-Fa-f,]+)`)
+
+// Parse reads the registers out of a trace line.  lines without a pc,
+// like headers, aren't states
+func Parse(line string) (State, bool) {
+	var s State
+
+	for _, m := range token.FindAllStringSubmatch(line, -1) {
+		key, value := strings.ToUpper(m[1]), m[2]
+
+		if key == "PCMEM" || key == "MEM" {
+			if mem, ok := parseBytes(value); ok {
+				s.Mem = mem
+				s.fields |= fieldMem
+			}
+			continue
+		}
+
+		if key == "F" && isFlags(value) {
+			s.F = parseFlags(value)
+			s.fields |= fieldF
+			continue
+		}
+
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(value, "$"), "0x"), 16, 16)
+		if err != nil {
+			continue
+		}
+
+		s.set(key, uint16(n))
+	}
+
+	return s, s.fields&fieldPC != 0
+}
+
+func (s *State) set(key string, n uint16) {
+	hi, lo := byte(n>>8), byte(n)
+
+	switch key {
+	case "A":
+		s.A, s.fields = lo, s.fields|fieldA
+	case "F":
+		s.F, s.fields = lo, s.fields|fieldF
+	case "B":
+		s.B, s.fields = lo, s.fields|fieldB
+	case "C":
+		s.C, s.fields = lo, s.fields|fieldC
+	case "D":
+		s.D, s.fields = lo, s.fields|fieldD
+	case "E":
+		s.E, s.fields = lo, s.fields|fieldE
+	case "H":
+		s.H, s.fields = lo, s.fields|fieldH
+	case "L":
+		s.L, s.fields = lo, s.fields|fieldL
+	case "AF":
+		s.A, s.F, s.fields = hi, lo, s.fields|fieldA|fieldF
+	case "BC":
+		s.B, s.C, s.fields = hi, lo, s.fields|fieldB|fieldC
+	case "DE":
+		s.D, s.E, s.fields = hi, lo, s.fields|fieldD|fieldE
+	case "HL":
+		s.H, s.L, s.fields = hi, lo, s.fields|fieldH|fieldL
+	case "SP":
+		s.SP, s.fields = n, s.fields|fieldSP
+	case "PC":
+		s.PC, s.fields = n, s.fields|fieldPC
+	}
+}
+
+func isFlags(value string) bool {
+	return len(value) == 4 && strings.ContainsAny(value, "-znhcZNHC") && strings.Trim(value, "-ZNHCznhc") == ""
+}
+
+// "Z-HC" style flags, where upper case letters are set
+func parseFlags(value string) byte {
+	var f byte
+	for i, c := range value {
+		if c == rune("ZNHC"[i]) {
+			f |= 0x80 >> i
+		}
+	}
+
+	return f
+}
+
+func parseBytes(value string) ([]byte, bool) {
+	var mem []byte
+	for _, b := range strings.Split(value, ",") {
+		n, err := strconv.ParseUint(b, 16, 8)
+		if err != nil {
+			return nil, false
+		}
+		mem = append(mem, byte(n))
+	}
+
+	return mem, true
+}
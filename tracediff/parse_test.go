@@ -0,0 +1,81 @@
+package tracediff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want State
+	}{
+		{
+			"gameboy-doctor",
+			"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02",
+			State{A: 0x01, F: 0xb0, B: 0x00, C: 0x13, D: 0x00, E: 0xd8, H: 0x01, L: 0x4d, SP: 0xfffe, PC: 0x0100,
+				Mem: []byte{0x00, 0xc3, 0x13, 0x02}, fields: fieldA | fieldF | fieldB | fieldC | fieldD | fieldE | fieldH | fieldL | fieldSP | fieldPC | fieldMem},
+		},
+		{
+			"goboy with a label",
+			"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0150 PCMEM:F3,31,FE,FF ; Main+$3",
+			State{A: 0x01, F: 0xb0, B: 0x00, C: 0x13, D: 0x00, E: 0xd8, H: 0x01, L: 0x4d, SP: 0xfffe, PC: 0x0150,
+				Mem: []byte{0xf3, 0x31, 0xfe, 0xff}, fields: fieldA | fieldF | fieldB | fieldC | fieldD | fieldE | fieldH | fieldL | fieldSP | fieldPC | fieldMem},
+		},
+		{
+			"binjgb",
+			"A:01 F:Z-HC BC:0013 DE:00d8 HL:014d SP:fffe PC:0100 (cy: 0) ppu:+0 |[00]0x0100: 00        nop",
+			State{A: 0x01, F: 0xb0, B: 0x00, C: 0x13, D: 0x00, E: 0xd8, H: 0x01, L: 0x4d, SP: 0xfffe, PC: 0x0100,
+				fields: fieldA | fieldF | fieldB | fieldC | fieldD | fieldE | fieldH | fieldL | fieldSP | fieldPC},
+		},
+		{
+			"binjgb lower case flags",
+			"A:00 F:-n-c BC:cccc DE:0000 HL:0000 SP:fffe PC:0200",
+			State{F: 0x00, B: 0xcc, C: 0xcc, SP: 0xfffe, PC: 0x0200,
+				fields: fieldA | fieldF | fieldB | fieldC | fieldD | fieldE | fieldH | fieldL | fieldSP | fieldPC},
+		},
+		{
+			"bgb",
+			"AF=01B0 BC=0013 DE=00D8 HL=014D SP=FFFE PC=0100",
+			State{A: 0x01, F: 0xb0, B: 0x00, C: 0x13, D: 0x00, E: 0xd8, H: 0x01, L: 0x4d, SP: 0xfffe, PC: 0x0100,
+				fields: fieldA | fieldF | fieldB | fieldC | fieldD | fieldE | fieldH | fieldL | fieldSP | fieldPC},
+		},
+		{
+			"bgb with prefixes",
+			"PC=$0150 SP = 0xDFF0 AF=$1180",
+			State{A: 0x11, F: 0x80, SP: 0xdff0, PC: 0x0150, fields: fieldA | fieldF | fieldSP | fieldPC},
+		},
+	}
+
+	for _, test := range tests {
+		got, ok := Parse(test.line)
+		if !ok {
+			t.Errorf("%s: no state in %q", test.name, test.line)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseNotState(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"# goboy trace",
+		"A:01 F:B0 B:00 C:13",
+		"PC:zzzz",
+	} {
+		if s, ok := Parse(line); ok {
+			t.Errorf("%q: got %+v, want no state", line, s)
+		}
+	}
+}
+
+func TestParseBadMem(t *testing.T) {
+	s, ok := Parse("PC:0100 PCMEM:00,C3,,02")
+	if !ok || s.fields&fieldMem != 0 || s.Mem != nil {
+		t.Errorf("got %+v, %v, want a pc and no memory", s, ok)
+	}
+}